@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/push"
+)
+
+/*
+Administrative commands run instead of the server when arguments are given, e.g.
+
+	gowebserver promote-admin admin@example.com
+	gowebserver generate-vapid-keys
+*/
+func runCommand(db *database.Queries, args []string) error {
+	switch args[0] {
+	case "promote-admin":
+		if len(args) != 2 {
+			return errors.New("usage: promote-admin <email>")
+		}
+		sqlUser, err := db.SetUserRoleByEmail(context.Background(), database.SetUserRoleByEmailParams{
+			Email: args[1],
+			Role:  string(auth.RoleAdmin),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to promote %s: %w", args[1], err)
+		}
+		fmt.Printf("Promoted %s (%s) to admin\n", sqlUser.Email, sqlUser.ID)
+		return nil
+	case "generate-vapid-keys":
+		keys, err := push.GenerateVAPIDKeys("")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("VAPID_PRIVATE_KEY=%s\n", keys.PrivateKeyString())
+		fmt.Printf("# public key: %s\n", keys.PublicKeyString())
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
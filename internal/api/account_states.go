@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+const (
+	AccountActive       = "active"
+	AccountSuspended    = "suspended"
+	AccountShadowBanned = "shadow_banned"
+)
+
+// Moderation log actions for account state changes made outside the queue.
+const (
+	ModerationShadowBanUser = "shadow_ban_user"
+	ModerationRestoreUser   = "restore_user"
+)
+
+const maxAccountStateReasonLength = 1000
+
+type AccountState struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	State          string     `json:"state"`
+	Reason         string     `json:"reason"`
+	SuspendedUntil *time.Time `json:"suspended_until"`
+}
+
+func ReadyAccountStateForJSON(userID uuid.UUID, sqlState database.GetUserAccountStateRow) AccountState {
+	state := AccountState{
+		UserID: userID,
+		State:  sqlState.AccountState,
+		Reason: sqlState.AccountStateReason,
+	}
+	// A suspension that has run out is reported as what it now is.
+	if state.State == AccountSuspended {
+		if until, suspended := suspendedUntil(sqlState.AccountState, sqlState.SuspendedUntil); suspended {
+			state.SuspendedUntil = &until
+		} else {
+			state.State = AccountActive
+		}
+	}
+	return state
+}
+
+/*
+Change a user's account state. Suspending a user also revokes their refresh
+tokens so they are signed out once their current access token expires.
+*/
+func (cfg *APIConfig) setAccountState(ctx context.Context, userID uuid.UUID, state, reason string, suspendedUntil time.Time) error {
+	params := database.SetUserAccountStateParams{
+		ID:                 userID,
+		AccountState:       state,
+		AccountStateReason: reason,
+	}
+	if state == AccountSuspended {
+		params.SuspendedUntil = sql.NullTime{Time: suspendedUntil, Valid: true}
+	}
+	if err := cfg.DBQueries.SetUserAccountState(ctx, params); err != nil {
+		return err
+	}
+	if state != AccountSuspended {
+		return nil
+	}
+	return cfg.DBQueries.RevokeUserRefreshTokens(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+}
+
+/*
+Whether actorID holds a higher role than targetID, going by the roles stored
+now rather than those in either token. Staff can only act against users they
+outrank, so a moderator cannot sanction an admin or another moderator.
+*/
+func (cfg *APIConfig) outranks(ctx context.Context, actorID, targetID uuid.UUID) (bool, error) {
+	actor, err := cfg.DBQueries.GetUser(ctx, actorID)
+	if err != nil {
+		return false, err
+	}
+	target, err := cfg.DBQueries.GetUser(ctx, targetID)
+	if err != nil {
+		return false, err
+	}
+	return !auth.Role(target.Role).AtLeast(auth.Role(actor.Role)), nil
+}
+
+func (cfg *APIConfig) GetAccountStateHandler(response http.ResponseWriter, request *http.Request) {
+	userID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+	sqlState, err := cfg.DBQueries.GetUserAccountState(request.Context(), userID)
+	if err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+
+	data, encErr := json.Marshal(ReadyAccountStateForJSON(userID, sqlState))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+/*
+Suspend, shadow-ban or restore a user. Setting the state to active clears
+any earlier suspension or shadow-ban. Every change needs a reason, which is
+kept on the user and written to the moderation log.
+*/
+func (cfg *APIConfig) SetAccountStateHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		State          string     `json:"state"`
+		Reason         string     `json:"reason"`
+		SuspendedUntil *time.Time `json:"suspended_until"`
+	}
+
+	moderatorID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+	if _, err := cfg.DBQueries.GetUser(request.Context(), userID); err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+	if userID == moderatorID {
+		respondWithError(response, 400, "Cannot change your own account state")
+		return
+	}
+	if outranks, err := cfg.outranks(request.Context(), moderatorID, userID); err != nil {
+		respondWithError(response, 500, "Server failed to check roles")
+		return
+	} else if !outranks {
+		respondWithError(response, 403, "Cannot change the account state of a user with an equal or higher role")
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	var action string
+	var until time.Time
+	switch params.State {
+	case AccountActive:
+		action = ModerationRestoreUser
+	case AccountShadowBanned:
+		action = ModerationShadowBanUser
+	case AccountSuspended:
+		action = ModerationSuspendUser
+		if params.SuspendedUntil == nil || !params.SuspendedUntil.After(time.Now()) {
+			respondWithError(response, 400, "suspended_until must be in the future")
+			return
+		}
+		until = *params.SuspendedUntil
+	default:
+		respondWithError(response, 400, fmt.Sprintf("Unknown account state %q", params.State))
+		return
+	}
+	if len(params.Reason) == 0 {
+		respondWithError(response, 400, "A reason is required")
+		return
+	}
+	if len(params.Reason) > maxAccountStateReasonLength {
+		respondWithError(response, 400, "Reason is too long")
+		return
+	}
+
+	if err := cfg.setAccountState(request.Context(), userID, params.State, params.Reason, until); err != nil {
+		respondWithError(response, 500, "Server failed to update account state")
+		return
+	}
+	note := params.Reason
+	if params.State == AccountSuspended {
+		note = fmt.Sprintf("suspended until %s. %s", until.UTC().Format(time.RFC3339), params.Reason)
+	}
+	cfg.logModeration(request.Context(), database.AppendModerationLogParams{
+		ModeratorID: moderatorID,
+		Action:      action,
+		SubjectID:   uuid.NullUUID{UUID: userID, Valid: true},
+		Note:        note,
+	})
+
+	sqlState, err := cfg.DBQueries.GetUserAccountState(request.Context(), userID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get account state")
+		return
+	}
+	data, encErr := json.Marshal(ReadyAccountStateForJSON(userID, sqlState))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
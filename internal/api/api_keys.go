@@ -0,0 +1,190 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Key        string     `json:"key,omitempty"`
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func ReadyAPIKeyForJSON(sqlKey database.ApiKey) APIKey {
+	return APIKey{
+		ID:         sqlKey.ID,
+		CreatedAt:  sqlKey.CreatedAt,
+		Name:       sqlKey.Name,
+		Prefix:     sqlKey.Prefix,
+		Scopes:     sqlKey.Scopes,
+		ExpiresAt:  nullTimePtr(sqlKey.ExpiresAt),
+		LastUsedAt: nullTimePtr(sqlKey.LastUsedAt),
+	}
+}
+
+// Managing keys requires a JWT so that a leaked key cannot mint more keys.
+func (cfg *APIConfig) validateUserJWT(response http.ResponseWriter, request *http.Request) (uuid.UUID, bool) {
+	token, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		respondWithError(response, 401, "Malformed request")
+		return uuid.UUID{0}, false
+	}
+
+	validatedID, err := auth.ValidateJWT(token, cfg.Secret)
+	if err != nil {
+		respondWithError(response, 401, fmt.Sprintf("Unauthorized - %v", err.Error()))
+		return uuid.UUID{0}, false
+	}
+	if err := cfg.checkWriteAllowed(request, validatedID); err != nil {
+		respondWithAuthError(response, err)
+		return uuid.UUID{0}, false
+	}
+	return validatedID, true
+}
+
+func (cfg *APIConfig) CreateAPIKeyHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	if len(params.Name) == 0 {
+		respondWithError(response, 400, "API key name is required")
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(response, 400, "At least one scope is required")
+		return
+	}
+	for _, scope := range params.Scopes {
+		if _, err := auth.ParseScope(scope); err != nil {
+			respondWithError(response, 400, fmt.Sprintf("Unknown scope %q", scope))
+			return
+		}
+	}
+
+	var expiresAt sql.NullTime
+	if params.ExpiresAt != nil {
+		if params.ExpiresAt.Before(time.Now()) {
+			respondWithError(response, 400, "Expiry must be in the future")
+			return
+		}
+		expiresAt = sql.NullTime{Time: *params.ExpiresAt, Valid: true}
+	}
+
+	key, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(response, 500, "Server failed to generate API key")
+		return
+	}
+
+	sqlKey, err := cfg.DBQueries.CreateAPIKey(request.Context(), database.CreateAPIKeyParams{
+		UserID:    validatedID,
+		Name:      params.Name,
+		Prefix:    auth.APIKeyPrefix(key),
+		HashedKey: auth.HashAPIKey(key),
+		Scopes:    params.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to create API key")
+		return
+	}
+
+	cfg.audit(request.Context(), AuditAPIKeyCreated, userIDOf(validatedID), userIDOf(validatedID),
+		fmt.Sprintf("key %s (%s) scopes %s", sqlKey.ID, sqlKey.Prefix, strings.Join(sqlKey.Scopes, " ")))
+
+	respBody := ReadyAPIKeyForJSON(sqlKey)
+	respBody.Key = key
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 201, data)
+}
+
+func (cfg *APIConfig) GetAPIKeysHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	sqlKeys, err := cfg.DBQueries.GetAPIKeysForUser(request.Context(), validatedID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get API keys")
+		return
+	}
+
+	respBody := []APIKey{}
+	for _, sqlKey := range sqlKeys {
+		respBody = append(respBody, ReadyAPIKeyForJSON(sqlKey))
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) RevokeAPIKeyHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	keyID, err := uuid.Parse(request.PathValue("keyID"))
+	if err != nil {
+		respondWithError(response, 404, "API key not found")
+		return
+	}
+
+	sqlKey, err := cfg.DBQueries.RevokeAPIKey(request.Context(), database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: validatedID,
+	})
+	if err != nil {
+		respondWithError(response, 404, "API key not found")
+		return
+	}
+	cfg.audit(request.Context(), AuditAPIKeyRevoked, userIDOf(validatedID), userIDOf(validatedID),
+		fmt.Sprintf("key %s (%s)", sqlKey.ID, sqlKey.Prefix))
+	response.WriteHeader(204)
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/audit"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+// Actions recorded in the audit log. Chirpy Red and moderation actions are
+// recorded under a prefix followed by the event or moderation action.
+const (
+	AuditLoginSucceeded     = "login.succeeded"
+	AuditLoginFailed        = "login.failed"
+	AuditTokenRefreshed     = "token.refreshed"
+	AuditTokenRevoked       = "token.revoked"
+	AuditCredentialsUpdated = "credentials.updated"
+	AuditAPIKeyCreated      = "api_key.created"
+	AuditAPIKeyRevoked      = "api_key.revoked"
+	AuditRoleChanged        = "role.changed"
+	AuditAdminReset         = "admin.reset"
+	AuditChirpyRedPrefix    = "chirpy_red."
+	AuditModerationPrefix   = "moderation."
+)
+
+const (
+	auditVerifyPageSize   = 1000
+	maxAuditDetailsLength = 1000
+	maxUserAgentLength    = 512
+	requestIDHeader       = "X-Request-ID"
+)
+
+type requestInfoKey struct{}
+
+// Who sent a request, as recorded in the audit log.
+type requestInfo struct {
+	ID        string
+	IP        string
+	UserAgent string
+}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+/*
+Tag every request with an ID, echoed in the X-Request-ID response header, and
+remember where it came from. A well-formed ID sent by the client or a proxy
+in front of us is kept so logs can be correlated across both.
+*/
+func (cfg *APIConfig) MiddlewareRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		userAgent := auditText(r.UserAgent(), maxUserAgentLength)
+		info := requestInfo{ID: id, IP: cfg.clientIP(r), UserAgent: userAgent}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+	})
+}
+
+/*
+X-Forwarded-For is only believed as far as our own proxies wrote it. Each one
+appends the address it received the request from, so the client is the entry
+TrustedProxies from the right; anything further left came from the client
+and could be anything.
+*/
+func (cfg *APIConfig) clientIP(request *http.Request) string {
+	if cfg.TrustedProxies > 0 {
+		var hops []string
+		for _, forwarded := range request.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(forwarded, ",")...)
+		}
+		if len(hops) >= cfg.TrustedProxies {
+			if ip := net.ParseIP(strings.TrimSpace(hops[len(hops)-cfg.TrustedProxies])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+func requestInfoFrom(ctx context.Context) requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+	return info
+}
+
+/*
+Append a record to the audit log. Records are chained under a lock so that
+each one hashes the one before it. Failures are logged rather than returned:
+the action being audited has already happened.
+*/
+func (cfg *APIConfig) audit(ctx context.Context, action string, actorID, targetID uuid.NullUUID, details string) {
+	details = auditText(details, maxAuditDetailsLength)
+	info := requestInfoFrom(ctx)
+	record := audit.Record{
+		CreatedAt: audit.Now(),
+		Action:    action,
+		ActorID:   actorID,
+		TargetID:  targetID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		RequestID: info.ID,
+		Details:   details,
+	}
+	if err := cfg.appendAuditRecord(ctx, record); err != nil {
+		fmt.Printf("Error: failed to write audit record %s - %s\n", action, err.Error())
+	}
+}
+
+func (cfg *APIConfig) appendAuditRecord(ctx context.Context, record audit.Record) error {
+	tx, err := cfg.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	queries := cfg.DBQueries.WithTx(tx)
+
+	if err := queries.LockAuditLog(ctx); err != nil {
+		return err
+	}
+	prevHash, err := queries.GetLatestAuditHash(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	record = audit.Chain(prevHash, record)
+	_, err = queries.InsertAuditLog(ctx, database.InsertAuditLogParams{
+		CreatedAt: record.CreatedAt,
+		Action:    record.Action,
+		ActorID:   record.ActorID,
+		TargetID:  record.TargetID,
+		Ip:        record.IP,
+		UserAgent: record.UserAgent,
+		RequestID: record.RequestID,
+		Details:   record.Details,
+		PrevHash:  record.PrevHash,
+		Hash:      record.Hash,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+/*
+Make client-supplied text safe to store: Postgres refuses invalid UTF-8 and
+NUL bytes, and a refused insert would leave the action unrecorded. Long text
+is cut at a rune boundary at or below maxBytes.
+*/
+func auditText(s string, maxBytes int) string {
+	s = strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", "")
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
+
+func userIDOf(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: true}
+}
+
+// The user behind the request's bearer JWT, if there is a valid one.
+func (cfg *APIConfig) requestUserID(request *http.Request) uuid.NullUUID {
+	token, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	userID, err := auth.ValidateJWT(token, cfg.Secret)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	return userIDOf(userID)
+}
+
+func auditRecordFromSQL(sqlRecord database.AuditLog) audit.Record {
+	return audit.Record{
+		ID:        sqlRecord.ID,
+		CreatedAt: sqlRecord.CreatedAt,
+		Action:    sqlRecord.Action,
+		ActorID:   sqlRecord.ActorID,
+		TargetID:  sqlRecord.TargetID,
+		IP:        sqlRecord.Ip,
+		UserAgent: sqlRecord.UserAgent,
+		RequestID: sqlRecord.RequestID,
+		Details:   sqlRecord.Details,
+		PrevHash:  sqlRecord.PrevHash,
+		Hash:      sqlRecord.Hash,
+	}
+}
+
+type AuditRecord struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Action    string     `json:"action"`
+	ActorID   *uuid.UUID `json:"actor_id"`
+	TargetID  *uuid.UUID `json:"target_id"`
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"user_agent"`
+	RequestID string     `json:"request_id"`
+	Details   string     `json:"details,omitempty"`
+	PrevHash  string     `json:"prev_hash"`
+	Hash      string     `json:"hash"`
+}
+
+func ReadyAuditRecordForJSON(sqlRecord database.AuditLog) AuditRecord {
+	return AuditRecord{
+		ID:        sqlRecord.ID,
+		CreatedAt: sqlRecord.CreatedAt,
+		Action:    sqlRecord.Action,
+		ActorID:   nullUUIDPtr(sqlRecord.ActorID),
+		TargetID:  nullUUIDPtr(sqlRecord.TargetID),
+		IP:        sqlRecord.Ip,
+		UserAgent: sqlRecord.UserAgent,
+		RequestID: sqlRecord.RequestID,
+		Details:   sqlRecord.Details,
+		PrevHash:  sqlRecord.PrevHash,
+		Hash:      sqlRecord.Hash,
+	}
+}
+
+/*
+Query the audit log, newest first. Filters: action (exact, or a prefix ending
+in '.' such as "login."), actor_id, target_id, and since/until as RFC 3339
+times. Pages continue from next_cursor.
+*/
+func (cfg *APIConfig) GetAuditLogHandler(response http.ResponseWriter, request *http.Request) {
+	type responseParameters struct {
+		Records    []AuditRecord `json:"records"`
+		NextCursor string        `json:"next_cursor,omitempty"`
+	}
+
+	query := request.URL.Query()
+	limit := 100
+	if urlLimit := query.Get("limit"); len(urlLimit) != 0 {
+		if n, err := strconv.Atoi(urlLimit); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+	params := database.ListAuditLogParams{
+		BeforeID:   math.MaxInt64,
+		MaxResults: int32(limit),
+	}
+	if cursor := query.Get("cursor"); len(cursor) != 0 {
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n <= 0 {
+			respondWithError(response, 400, "Invalid cursor")
+			return
+		}
+		params.BeforeID = n
+	}
+	if action := query.Get("action"); len(action) != 0 {
+		params.Action = sql.NullString{String: action, Valid: true}
+	}
+	for name, field := range map[string]*uuid.NullUUID{"actor_id": &params.ActorID, "target_id": &params.TargetID} {
+		if value := query.Get(name); len(value) != 0 {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				respondWithError(response, 400, fmt.Sprintf("Invalid %s", name))
+				return
+			}
+			*field = userIDOf(id)
+		}
+	}
+	for name, field := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+		if value := query.Get(name); len(value) != 0 {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondWithError(response, 400, fmt.Sprintf("%s must be an RFC 3339 time", name))
+				return
+			}
+			*field = sql.NullTime{Time: t.UTC(), Valid: true}
+		}
+	}
+
+	sqlRecords, err := cfg.DBQueries.ListAuditLog(request.Context(), params)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get audit log")
+		return
+	}
+
+	respBody := responseParameters{Records: []AuditRecord{}}
+	for _, sqlRecord := range sqlRecords {
+		respBody.Records = append(respBody.Records, ReadyAuditRecordForJSON(sqlRecord))
+	}
+	if len(sqlRecords) == limit {
+		respBody.NextCursor = strconv.FormatInt(sqlRecords[len(sqlRecords)-1].ID, 10)
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+// Walk the whole chain and report the first record that breaks it, if any.
+func (cfg *APIConfig) VerifyAuditLogHandler(response http.ResponseWriter, request *http.Request) {
+	type responseParameters struct {
+		Valid    bool   `json:"valid"`
+		Verified int    `json:"verified"`
+		BrokenAt int64  `json:"broken_at,omitempty"`
+		Reason   string `json:"reason,omitempty"`
+	}
+
+	respBody := responseParameters{Valid: true}
+	var afterID int64
+	prevHash := ""
+	for {
+		sqlRecords, err := cfg.DBQueries.GetAuditLogAfter(request.Context(), database.GetAuditLogAfterParams{
+			ID:    afterID,
+			Limit: auditVerifyPageSize,
+		})
+		if err != nil {
+			respondWithError(response, 500, "Server failed to read audit log")
+			return
+		}
+		records := make([]audit.Record, 0, len(sqlRecords))
+		for _, sqlRecord := range sqlRecords {
+			records = append(records, auditRecordFromSQL(sqlRecord))
+		}
+
+		prevHash, err = audit.Verify(prevHash, records)
+		var broken audit.BrokenChainError
+		if errors.As(err, &broken) {
+			respBody.Valid = false
+			respBody.BrokenAt = broken.ID
+			respBody.Reason = broken.Reason
+			for _, r := range records {
+				if r.ID == broken.ID {
+					break
+				}
+				respBody.Verified++
+			}
+			break
+		}
+		respBody.Verified += len(records)
+		if len(sqlRecords) < auditVerifyPageSize {
+			break
+		}
+		afterID = sqlRecords[len(sqlRecords)-1].ID
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+)
+
+var errInsufficientScope = errors.New("credentials lack required scope")
+
+// Returned when a suspended user tries to sign in or change anything.
+type accountSuspendedError struct {
+	until time.Time
+}
+
+func (err accountSuspendedError) Error() string {
+	return fmt.Sprintf("Account suspended until %s", err.until.UTC().Format(time.RFC3339))
+}
+
+/*
+Identify the caller from either a bearer JWT or a personal API key.
+First-party JWTs carry the user's full authority; API keys and tokens
+issued to OAuth clients must include the given scope.
+*/
+func (cfg *APIConfig) authenticate(request *http.Request, scope auth.Scope) (uuid.UUID, error) {
+	if token, err := auth.GetBearerToken(request.Header); err == nil {
+		accessToken, err := auth.ValidateAccessToken(token, cfg.Secret)
+		if err != nil {
+			return uuid.UUID{0}, err
+		}
+		if !accessToken.Allows(scope) {
+			return uuid.UUID{0}, errInsufficientScope
+		}
+		return accessToken.UserID, cfg.checkWriteAllowed(request, accessToken.UserID)
+	}
+
+	key, err := auth.GetAPIKey(request.Header)
+	if err != nil {
+		return uuid.UUID{0}, err
+	}
+
+	sqlKey, err := cfg.DBQueries.GetAPIKeyByHash(request.Context(), auth.HashAPIKey(key))
+	if err != nil {
+		return uuid.UUID{0}, errors.New("invalid API key")
+	}
+	if sqlKey.RevokedAt.Valid {
+		return uuid.UUID{0}, errors.New("API key revoked")
+	}
+	if sqlKey.ExpiresAt.Valid && time.Now().After(sqlKey.ExpiresAt.Time) {
+		return uuid.UUID{0}, errors.New("API key expired")
+	}
+	if !slices.Contains(sqlKey.Scopes, string(scope)) {
+		return uuid.UUID{0}, errInsufficientScope
+	}
+
+	cfg.DBQueries.TouchAPIKey(request.Context(), sqlKey.ID)
+	return sqlKey.UserID, cfg.checkWriteAllowed(request, sqlKey.UserID)
+}
+
+// Whether the account state is an active suspension, and until when.
+func suspendedUntil(accountState string, until sql.NullTime) (time.Time, bool) {
+	if accountState == AccountSuspended && until.Valid && time.Now().Before(until.Time) {
+		return until.Time, true
+	}
+	return time.Time{}, false
+}
+
+/*
+Access tokens outlive a suspension being imposed, so every request that can
+change something looks up the caller's current state. Reads are unaffected.
+*/
+func (cfg *APIConfig) checkWriteAllowed(request *http.Request, userID uuid.UUID) error {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	state, err := cfg.DBQueries.GetUserAccountState(request.Context(), userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if until, suspended := suspendedUntil(state.AccountState, state.SuspendedUntil); suspended {
+		return accountSuspendedError{until: until}
+	}
+	return nil
+}
+
+// Shadow-banned users are not told, so anything they do that others would see is dropped quietly.
+func (cfg *APIConfig) isShadowBanned(ctx context.Context, userID uuid.UUID) bool {
+	state, err := cfg.DBQueries.GetUserAccountState(ctx, userID)
+	return err == nil && state.AccountState == AccountShadowBanned
+}
+
+/*
+Identify the caller on endpoints that anonymous clients may also use, so the
+response can be tailored to them. Requests without credentials are anonymous;
+credentials that are present but invalid are still an error.
+*/
+func (cfg *APIConfig) viewer(request *http.Request) (uuid.NullUUID, error) {
+	if request.Header.Get("Authorization") == "" {
+		return uuid.NullUUID{}, nil
+	}
+	viewerID, err := cfg.authenticate(request, auth.ScopeChirpsRead)
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+	return uuid.NullUUID{UUID: viewerID, Valid: true}, nil
+}
+
+func respondWithAuthError(response http.ResponseWriter, err error) {
+	if errors.Is(err, errInsufficientScope) {
+		respondWithError(response, 403, err.Error())
+		return
+	}
+	if errors.As(err, &accountSuspendedError{}) {
+		respondWithError(response, 403, err.Error())
+		return
+	}
+	respondWithError(response, 401, fmt.Sprintf("Unauthorized - %v", err.Error()))
+}
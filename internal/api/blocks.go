@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+// Whether either user has blocked the other. Errors count as blocked so that a failed check never lets an interaction through.
+func (cfg *APIConfig) isBlockedBetween(ctx context.Context, userA, userB uuid.UUID) bool {
+	blocked, err := cfg.DBQueries.IsBlockedBetween(ctx, database.IsBlockedBetweenParams{
+		UserA: userA,
+		UserB: userB,
+	})
+	if err != nil {
+		fmt.Println("Error: failed to check blocks -", err.Error())
+		return true
+	}
+	return blocked
+}
+
+// Parse the target of a block or mute request, answering the request itself when it is invalid.
+func (cfg *APIConfig) relationshipTarget(response http.ResponseWriter, request *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return uuid.UUID{0}, uuid.UUID{0}, false
+	}
+
+	targetID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		respondWithError(response, 404, "User not found")
+		return uuid.UUID{0}, uuid.UUID{0}, false
+	}
+	if targetID == validatedID {
+		respondWithError(response, 400, "Cannot target yourself")
+		return uuid.UUID{0}, uuid.UUID{0}, false
+	}
+	if _, err := cfg.DBQueries.GetUser(request.Context(), targetID); err != nil {
+		respondWithError(response, 404, "User not found")
+		return uuid.UUID{0}, uuid.UUID{0}, false
+	}
+	return validatedID, targetID, true
+}
+
+/*
+Block a user. Neither side can follow, reply to or mention the other
+afterwards, and each stops seeing the other's chirps. Existing follows in
+both directions are removed.
+*/
+func (cfg *APIConfig) BlockUserHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, targetID, ok := cfg.relationshipTarget(response, request)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.DBQueries.BlockUser(request.Context(), database.BlockUserParams{
+		BlockerID: validatedID,
+		BlockedID: targetID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to block user")
+		return
+	}
+	err = cfg.DBQueries.DeleteFollowsBetween(request.Context(), database.DeleteFollowsBetweenParams{
+		UserA: validatedID,
+		UserB: targetID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to block user")
+		return
+	}
+	response.WriteHeader(204)
+}
+
+func (cfg *APIConfig) UnblockUserHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, targetID, ok := cfg.relationshipTarget(response, request)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.DBQueries.UnblockUser(request.Context(), database.UnblockUserParams{
+		BlockerID: validatedID,
+		BlockedID: targetID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to unblock user")
+		return
+	}
+	response.WriteHeader(204)
+}
+
+// Mute a user: their chirps are silently left out of the caller's timelines and streams.
+func (cfg *APIConfig) MuteUserHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, targetID, ok := cfg.relationshipTarget(response, request)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.DBQueries.MuteUser(request.Context(), database.MuteUserParams{
+		MuterID: validatedID,
+		MutedID: targetID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to mute user")
+		return
+	}
+	response.WriteHeader(204)
+}
+
+func (cfg *APIConfig) UnmuteUserHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, targetID, ok := cfg.relationshipTarget(response, request)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.DBQueries.UnmuteUser(request.Context(), database.UnmuteUserParams{
+		MuterID: validatedID,
+		MutedID: targetID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to unmute user")
+		return
+	}
+	response.WriteHeader(204)
+}
@@ -1,41 +1,56 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"net/http"
-	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/notsoexpert/gowebserver/internal/auth"
 	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/spam"
 )
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body,omitempty"`
-	UserID    uuid.UUID `json:"user_id"`
-	Error     string    `json:"error,omitempty"`
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Body      string     `json:"body,omitempty"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ReplyToID *uuid.UUID `json:"reply_to_id,omitempty"`
+	Hidden    bool       `json:"hidden,omitempty"`
+	// Effective flags, with any moderator override applied.
+	ContentWarning string `json:"content_warning,omitempty"`
+	Sensitive      bool   `json:"sensitive,omitempty"`
+	// Set when the body was withheld because of the viewer's sensitive content preference.
+	Collapsed bool   `json:"collapsed,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
 func ReadyChirpForJSON(sqlChirp database.Chirp) Chirp {
+	contentWarning, sensitive := effectiveContentFlags(sqlChirp.ContentWarning, sqlChirp.Sensitive, sqlChirp.ContentWarningOverride, sqlChirp.SensitiveOverride)
 	return Chirp{
-		ID:        sqlChirp.ID,
-		CreatedAt: sqlChirp.CreatedAt,
-		UpdatedAt: sqlChirp.UpdatedAt,
-		Body:      sqlChirp.Body,
-		UserID:    sqlChirp.UserID.UUID,
+		ID:             sqlChirp.ID,
+		CreatedAt:      sqlChirp.CreatedAt,
+		UpdatedAt:      sqlChirp.UpdatedAt,
+		Body:           sqlChirp.Body,
+		UserID:         sqlChirp.UserID.UUID,
+		ReplyToID:      nullUUIDPtr(sqlChirp.ReplyToID),
+		Hidden:         sqlChirp.HiddenAt.Valid,
+		ContentWarning: contentWarning,
+		Sensitive:      sensitive,
 	}
 }
 
 func (cfg *APIConfig) PostChirpsHandler(response http.ResponseWriter, request *http.Request) {
 	type requestParameters struct {
-		Body string `json:"body"`
+		Body           string     `json:"body"`
+		ReplyToID      *uuid.UUID `json:"reply_to_id"`
+		ContentWarning string     `json:"content_warning"`
+		Sensitive      bool       `json:"sensitive"`
 	}
 
 	decoder := json.NewDecoder(request.Body)
@@ -46,46 +61,101 @@ func (cfg *APIConfig) PostChirpsHandler(response http.ResponseWriter, request *h
 		return
 	}
 
-	token, err := auth.GetBearerToken(request.Header)
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
 	if err != nil {
-		respondWithError(response, 401, "Malformed request")
+		respondWithAuthError(response, err)
 		return
 	}
 
-	validatedID, err := auth.ValidateJWT(token, cfg.Secret)
-	if err != nil {
-		respondWithError(response, 401, fmt.Sprintf("Unauthorized - %v", err.Error()))
+	if len(params.Body) > 140 {
+		respondWithError(response, 400, "Chirp is too long")
+		return
+	}
+	params.ContentWarning = strings.TrimSpace(params.ContentWarning)
+	if !validContentWarning(params.ContentWarning) {
+		respondWithError(response, 400, "Content warning is too long")
 		return
 	}
 
-	if len(params.Body) > 140 {
-		respondWithError(response, 400, "Chirp is too long")
+	var replyToID uuid.NullUUID
+	if params.ReplyToID != nil {
+		// Chirps the author cannot see, including across a block, cannot be replied to.
+		parent, err := cfg.DBQueries.GetVisibleChirp(request.Context(), database.GetVisibleChirpParams{
+			ID:       *params.ReplyToID,
+			ViewerID: uuid.NullUUID{UUID: validatedID, Valid: true},
+		})
+		if err != nil {
+			respondWithError(response, 404, "Chirp being replied to not found")
+			return
+		}
+		replyToID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
+
+	verdict := cfg.checkSpam(request.Context(), validatedID, params.Body)
+	if verdict.Verdict == spam.Reject {
+		respondWithError(response, 400, verdict.Reason)
 		return
 	}
+	var hiddenAt sql.NullTime
+	if verdict.Verdict == spam.Hold {
+		hiddenAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
 
 	sqlChirp, err := cfg.DBQueries.PostChirp(request.Context(), database.PostChirpParams{
-		Body:   cleanResponseBody(params.Body),
-		UserID: uuid.NullUUID{UUID: validatedID, Valid: true},
+		Body:           cleanResponseBody(params.Body),
+		UserID:         uuid.NullUUID{UUID: validatedID, Valid: true},
+		ReplyToID:      replyToID,
+		HiddenAt:       hiddenAt,
+		ContentWarning: params.ContentWarning,
+		Sensitive:      params.Sensitive,
 	})
 	if err != nil {
 		respondWithError(response, 400, "Server failed to create chirp record")
 		return
 	}
 
-	respBody := ReadyChirpForJSON(sqlChirp)
+	// Held chirps stay visible to their author but wait for a moderator before anyone else sees them.
+	code := 201
+	if verdict.Verdict == spam.Hold {
+		cfg.flagChirp(request.Context(), sqlChirp, verdict)
+		code = 202
+	} else {
+		cfg.announceChirp(request.Context(), sqlChirp)
+	}
 
-	data, encErr := json.Marshal(respBody)
+	data, encErr := json.Marshal(ReadyChirpForJSON(sqlChirp))
 	if encErr != nil {
 		respondWithError(response, 500, "Server failed to encode response")
 		return
 	}
-	respondWithJSON(response, 201, data)
+	respondWithJSON(response, code, data)
+}
+
+/*
+Tell everyone interested about a newly visible chirp. Chirps by shadow-banned
+users are never announced.
+*/
+func (cfg *APIConfig) announceChirp(ctx context.Context, sqlChirp database.Chirp) {
+	if !sqlChirp.UserID.Valid || cfg.isShadowBanned(ctx, sqlChirp.UserID.UUID) {
+		return
+	}
+	var parentAuthorID uuid.NullUUID
+	if sqlChirp.ReplyToID.Valid {
+		if parent, err := cfg.DBQueries.GetChirp(ctx, sqlChirp.ReplyToID.UUID); err == nil {
+			parentAuthorID = parent.UserID
+		}
+	}
+
+	chirp := ReadyChirpForJSON(sqlChirp)
+	cfg.notifyChirpCreated(ctx, sqlChirp, parentAuthorID)
+	cfg.emitWebhookEvent(ctx, "chirp.created", []uuid.UUID{chirp.UserID}, chirp)
+	cfg.publishChirpEvent(ctx, "chirp.created", chirp, chirp)
 }
 
 func (cfg *APIConfig) GetChirpsHandler(response http.ResponseWriter, request *http.Request) {
-	sqlChirps, err := cfg.DBQueries.GetChirps(request.Context())
+	viewerID, err := cfg.viewer(request)
 	if err != nil {
-		respondWithError(response, 400, "Server failed to get chirp records")
+		respondWithAuthError(response, err)
 		return
 	}
 
@@ -106,26 +176,27 @@ func (cfg *APIConfig) GetChirpsHandler(response http.ResponseWriter, request *ht
 		}
 	}
 
-	switch sortType {
-	case "asc":
-		slices.SortFunc(sqlChirps, func(a database.Chirp, b database.Chirp) int {
-			return a.CreatedAt.Compare(b.CreatedAt)
-		})
-	case "desc":
-		slices.SortFunc(sqlChirps, func(a database.Chirp, b database.Chirp) int {
-			return -a.CreatedAt.Compare(b.CreatedAt)
-		})
+	sqlChirps, err := cfg.DBQueries.ListChirps(request.Context(), database.ListChirpsParams{
+		AuthorID:    authorID,
+		ViewerID:    viewerID,
+		NewestFirst: sortType == "desc",
+	})
+	if err != nil {
+		respondWithError(response, 400, "Server failed to get chirp records")
+		return
 	}
 
 	var respBody []Chirp
 
+	preference := cfg.sensitivePreference(request.Context(), viewerID)
+	muted := cfg.mutedWords(request.Context(), viewerID)
 	for _, sqlChirp := range sqlChirps {
-		if authorID.Valid {
-			if sqlChirp.UserID != authorID {
-				continue
-			}
+		if muted.mutes(viewerID, sqlChirp.UserID.UUID, sqlChirp.Body) {
+			continue
 		}
-		respBody = append(respBody, ReadyChirpForJSON(sqlChirp))
+		chirp := ReadyChirpForJSON(sqlChirp)
+		chirp.applySensitivePreference(viewerID, preference)
+		respBody = append(respBody, chirp)
 	}
 
 	data, encErr := json.Marshal(respBody)
@@ -137,36 +208,34 @@ func (cfg *APIConfig) GetChirpsHandler(response http.ResponseWriter, request *ht
 }
 
 func (cfg *APIConfig) GetChirpHandler(response http.ResponseWriter, request *http.Request) {
-	sqlChirps, err := cfg.DBQueries.GetChirps(request.Context())
+	viewerID, err := cfg.viewer(request)
 	if err != nil {
-		respondWithError(response, 400, "Server failed to get chirp records")
+		respondWithAuthError(response, err)
 		return
 	}
 
-	uuid, err := uuid.Parse(request.PathValue("chirpID"))
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
 	if err != nil {
 		respondWithError(response, 404, "Chirp not found")
 		return
 	}
 
-	var foundChirp Chirp
-	err = errors.New("Chirp not found")
-	for _, sqlChirp := range sqlChirps {
-		chirp := ReadyChirpForJSON(sqlChirp)
-
-		if chirp.ID == uuid {
-			foundChirp = chirp
-			err = nil
-			break
-		}
-	}
-
+	sqlChirp, err := cfg.DBQueries.GetVisibleChirp(request.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: viewerID,
+	})
 	if err != nil {
-		respondWithError(response, 404, err.Error())
+		respondWithError(response, 404, "Chirp not found")
 		return
 	}
 
-	data, encErr := json.Marshal(foundChirp)
+	// Opening a single chirp with expand=true is how viewers click through a content warning.
+	chirp := ReadyChirpForJSON(sqlChirp)
+	if request.URL.Query().Get("expand") != "true" {
+		chirp.applySensitivePreference(viewerID, cfg.sensitivePreference(request.Context(), viewerID))
+	}
+
+	data, encErr := json.Marshal(chirp)
 	if encErr != nil {
 		respondWithError(response, 500, "Server failed to encode response")
 		return
@@ -175,56 +244,61 @@ func (cfg *APIConfig) GetChirpHandler(response http.ResponseWriter, request *htt
 }
 
 func (cfg *APIConfig) DeleteChirpHandler(response http.ResponseWriter, request *http.Request) {
-	token, err := auth.GetBearerToken(request.Header)
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
 	if err != nil {
-		respondWithError(response, 401, "Malformed request")
+		respondWithAuthError(response, err)
 		return
 	}
 
-	validatedID, err := auth.ValidateJWT(token, cfg.Secret)
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(response, 403, fmt.Sprintf("Unauthorized - %v", err.Error()))
-		return
-	}
-
-	sqlChirps, err := cfg.DBQueries.GetChirps(request.Context())
-	if err != nil {
-		respondWithError(response, 400, "Server failed to get chirp records")
+		respondWithError(response, 404, "Chirp not found")
 		return
 	}
 
-	uuid, err := uuid.Parse(request.PathValue("chirpID"))
+	sqlChirp, err := cfg.DBQueries.GetChirp(request.Context(), chirpID)
 	if err != nil {
 		respondWithError(response, 404, "Chirp not found")
 		return
 	}
+	foundChirp := ReadyChirpForJSON(sqlChirp)
 
-	var foundChirp Chirp
-	err = errors.New("Chirp not found")
-	for _, sqlChirp := range sqlChirps {
-		chirp := ReadyChirpForJSON(sqlChirp)
-
-		if chirp.ID == uuid {
-			foundChirp = chirp
-			err = nil
-			break
+	// Moderators may remove anyone's chirp; doing so is logged.
+	moderated := false
+	if foundChirp.UserID != validatedID {
+		sqlUser, err := cfg.DBQueries.GetUser(request.Context(), validatedID)
+		if err != nil || !auth.Role(sqlUser.Role).AtLeast(auth.RoleModerator) {
+			respondWithError(response, 403, "Action not permitted")
+			return
 		}
+		moderated = true
 	}
 
-	if foundChirp.UserID != validatedID {
-		respondWithError(response, 403, "Action not permitted")
+	if err := cfg.removeChirp(request.Context(), foundChirp); err != nil {
+		respondWithError(response, 404, "Chirp not found")
 		return
 	}
-
-	if err != nil {
-		respondWithError(response, 404, err.Error())
-		return
+	if moderated {
+		cfg.logModeration(request.Context(), database.AppendModerationLogParams{
+			ModeratorID: validatedID,
+			Action:      ModerationDeleteChirp,
+			ChirpID:     uuid.NullUUID{UUID: foundChirp.ID, Valid: true},
+			SubjectID:   uuid.NullUUID{UUID: foundChirp.UserID, Valid: true},
+		})
 	}
+	response.WriteHeader(204)
+}
 
-	err = cfg.DBQueries.DeleteChirp(request.Context(), foundChirp.ID)
-	if err != nil {
-		respondWithError(response, 404, "Chirp not found")
-		return
+// Delete a chirp and tell webhook and stream subscribers about it.
+func (cfg *APIConfig) removeChirp(ctx context.Context, chirp Chirp) error {
+	if err := cfg.DBQueries.DeleteChirp(ctx, chirp.ID); err != nil {
+		return err
 	}
-	response.WriteHeader(204)
+	deleted := map[string]uuid.UUID{
+		"id":      chirp.ID,
+		"user_id": chirp.UserID,
+	}
+	cfg.emitWebhookEvent(ctx, "chirp.deleted", []uuid.UUID{chirp.UserID}, deleted)
+	cfg.publishChirpEvent(ctx, "chirp.deleted", chirp, deleted)
+	return nil
 }
@@ -1,15 +1,38 @@
 package api
 
 import (
+	"database/sql"
 	"sync/atomic"
+	"time"
 
+	"github.com/notsoexpert/gowebserver/internal/auth"
 	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/mail"
+	"github.com/notsoexpert/gowebserver/internal/push"
+	"github.com/notsoexpert/gowebserver/internal/ratelimit"
+	"github.com/notsoexpert/gowebserver/internal/spam"
+	"github.com/notsoexpert/gowebserver/internal/stream"
 )
 
 type APIConfig struct {
-	DBQueries      *database.Queries
-	fileserverHits atomic.Int32
-	Platform       string
-	Secret         string
-	PolkaKey       string
+	DB                  *sql.DB
+	DBQueries           *database.Queries
+	fileserverHits      atomic.Int32
+	Platform            string
+	Secret              string
+	PolkaKey            string
+	PolkaWebhookSecrets []string
+	OIDC                *auth.OIDCProvider
+	Mailer              mail.Mailer
+	BaseURL             string
+	Stream              *stream.Hub
+	Events              stream.Bus
+	Gateway             *Gateway
+	Push                *push.Sender
+	SpamChecks          spam.Chain
+	RateLimits          ratelimit.Store
+	// How long responses to requests with an Idempotency-Key are kept for replay.
+	IdempotencyWindow time.Duration
+	// How many proxies we run in front of the server, each appending to X-Forwarded-For.
+	TrustedProxies int
 }
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/stream"
+)
+
+// How a viewer wants chirps with a content warning or sensitive media shown.
+const (
+	SensitiveExpand = "expand"
+	SensitiveHide   = "hide"
+)
+
+const maxContentWarningLength = 100
+
+// Moderation log action for moderators changing a chirp's content flags.
+const ModerationSetContentFlags = "set_content_flags"
+
+// A moderator's override, where set, replaces what the author chose.
+func effectiveContentFlags(contentWarning string, sensitive bool, contentWarningOverride sql.NullString, sensitiveOverride sql.NullBool) (string, bool) {
+	if contentWarningOverride.Valid {
+		contentWarning = contentWarningOverride.String
+	}
+	if sensitiveOverride.Valid {
+		sensitive = sensitiveOverride.Bool
+	}
+	return contentWarning, sensitive
+}
+
+/*
+Withhold the body of a flagged chirp from a viewer who has not chosen to
+expand sensitive content. The content warning stays so clients can show it
+in place of the chirp, and authors always see their own chirps in full.
+*/
+func (chirp *Chirp) applySensitivePreference(viewerID uuid.NullUUID, preference string) {
+	if preference == SensitiveExpand || (viewerID.Valid && viewerID.UUID == chirp.UserID) {
+		return
+	}
+	if chirp.ContentWarning == "" && !chirp.Sensitive {
+		return
+	}
+	chirp.Body = ""
+	chirp.Collapsed = true
+}
+
+// Anonymous viewers, and any viewer whose preference cannot be read, get sensitive content hidden.
+func (cfg *APIConfig) sensitivePreference(ctx context.Context, viewerID uuid.NullUUID) string {
+	if !viewerID.Valid {
+		return SensitiveHide
+	}
+	preference, err := cfg.DBQueries.GetSensitiveContentPreference(ctx, viewerID.UUID)
+	if err != nil {
+		return SensitiveHide
+	}
+	return preference
+}
+
+// Stream events carry the chirp as published, so they are collapsed per subscriber on the way out.
+func collapseSensitiveEvent(event stream.Event, viewerID uuid.NullUUID, preference string) stream.Event {
+	if preference == SensitiveExpand || event.Type != "chirp.created" {
+		return event
+	}
+	var chirp Chirp
+	if err := json.Unmarshal(event.Data, &chirp); err != nil {
+		return event
+	}
+	chirp.applySensitivePreference(viewerID, preference)
+	if !chirp.Collapsed {
+		return event
+	}
+	data, err := json.Marshal(chirp)
+	if err != nil {
+		return event
+	}
+	event.Data = data
+	return event
+}
+
+func validContentWarning(contentWarning string) bool {
+	return len(contentWarning) <= maxContentWarningLength && !strings.ContainsAny(contentWarning, "\r\n")
+}
+
+func (cfg *APIConfig) GetPreferencesHandler(response http.ResponseWriter, request *http.Request) {
+	type responseParameters struct {
+		SensitiveContent string `json:"sensitive_content"`
+	}
+
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsRead)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	respBody := responseParameters{
+		SensitiveContent: cfg.sensitivePreference(request.Context(), uuid.NullUUID{UUID: validatedID, Valid: true}),
+	}
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) UpdatePreferencesHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		SensitiveContent string `json:"sensitive_content"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+	if params.SensitiveContent != SensitiveExpand && params.SensitiveContent != SensitiveHide {
+		respondWithError(response, 400, "sensitive_content must be expand or hide")
+		return
+	}
+
+	err := cfg.DBQueries.SetSensitiveContentPreference(request.Context(), database.SetSensitiveContentPreferenceParams{
+		ID:               validatedID,
+		SensitiveContent: params.SensitiveContent,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to update preferences")
+		return
+	}
+
+	data, encErr := json.Marshal(params)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+// Authors can change the content warning and sensitive flag on their own chirps at any time.
+func (cfg *APIConfig) SetChirpContentFlagsHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		ContentWarning string `json:"content_warning"`
+		Sensitive      bool   `json:"sensitive"`
+	}
+
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(response, 404, "Chirp not found")
+		return
+	}
+	sqlChirp, err := cfg.DBQueries.GetChirp(request.Context(), chirpID)
+	if err != nil {
+		respondWithError(response, 404, "Chirp not found")
+		return
+	}
+	if sqlChirp.UserID.UUID != validatedID {
+		respondWithError(response, 403, "Action not permitted")
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+	params.ContentWarning = strings.TrimSpace(params.ContentWarning)
+	if !validContentWarning(params.ContentWarning) {
+		respondWithError(response, 400, "Content warning is too long")
+		return
+	}
+
+	sqlChirp, err = cfg.DBQueries.SetChirpContentFlags(request.Context(), database.SetChirpContentFlagsParams{
+		ID:             chirpID,
+		ContentWarning: params.ContentWarning,
+		Sensitive:      params.Sensitive,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to update chirp")
+		return
+	}
+
+	data, encErr := json.Marshal(ReadyChirpForJSON(sqlChirp))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+/*
+Set moderator overrides for a chirp's content warning and sensitive flag.
+A null field removes that override and restores the author's choice; an
+empty content warning removes the author's warning. Fields left out of the
+request keep their current override.
+*/
+func (cfg *APIConfig) OverrideChirpContentFlagsHandler(response http.ResponseWriter, request *http.Request) {
+	// Raw so that a missing field (nil) can be told apart from an explicit null.
+	type requestParameters struct {
+		ContentWarning json.RawMessage `json:"content_warning"`
+		Sensitive      json.RawMessage `json:"sensitive"`
+	}
+
+	moderatorID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(response, 404, "Chirp not found")
+		return
+	}
+	sqlChirp, err := cfg.DBQueries.GetChirp(request.Context(), chirpID)
+	if err != nil {
+		respondWithError(response, 404, "Chirp not found")
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	overrides := database.SetChirpContentFlagOverridesParams{
+		ID:                     chirpID,
+		ContentWarningOverride: sqlChirp.ContentWarningOverride,
+		SensitiveOverride:      sqlChirp.SensitiveOverride,
+	}
+	if params.ContentWarning != nil {
+		var contentWarning *string
+		if err := json.Unmarshal(params.ContentWarning, &contentWarning); err != nil {
+			respondWithError(response, 400, "Malformed request")
+			return
+		}
+		overrides.ContentWarningOverride = sql.NullString{}
+		if contentWarning != nil {
+			trimmed := strings.TrimSpace(*contentWarning)
+			if !validContentWarning(trimmed) {
+				respondWithError(response, 400, "Content warning is too long")
+				return
+			}
+			overrides.ContentWarningOverride = sql.NullString{String: trimmed, Valid: true}
+		}
+	}
+	if params.Sensitive != nil {
+		var sensitive *bool
+		if err := json.Unmarshal(params.Sensitive, &sensitive); err != nil {
+			respondWithError(response, 400, "Malformed request")
+			return
+		}
+		overrides.SensitiveOverride = sql.NullBool{}
+		if sensitive != nil {
+			overrides.SensitiveOverride = sql.NullBool{Bool: *sensitive, Valid: true}
+		}
+	}
+
+	sqlChirp, err = cfg.DBQueries.SetChirpContentFlagOverrides(request.Context(), overrides)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to update chirp")
+		return
+	}
+	chirp := ReadyChirpForJSON(sqlChirp)
+	cfg.logModeration(request.Context(), database.AppendModerationLogParams{
+		ModeratorID: moderatorID,
+		Action:      ModerationSetContentFlags,
+		ChirpID:     uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		SubjectID:   sqlChirp.UserID,
+		Note:        fmt.Sprintf("content_warning=%q sensitive=%t", chirp.ContentWarning, chirp.Sensitive),
+	})
+
+	data, encErr := json.Marshal(chirp)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
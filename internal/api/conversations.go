@@ -0,0 +1,505 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/stream"
+)
+
+const (
+	maxConversationParticipants = 10
+	maxMessageLength            = 1000
+)
+
+type Conversation struct {
+	ID           uuid.UUID                 `json:"id"`
+	CreatedAt    time.Time                 `json:"created_at"`
+	UpdatedAt    time.Time                 `json:"updated_at"`
+	Participants []ConversationParticipant `json:"participants"`
+	Muted        bool                      `json:"muted"`
+	UnreadCount  int64                     `json:"unread_count"`
+}
+
+// last_read_message_id is the participant's read receipt.
+type ConversationParticipant struct {
+	UserID            uuid.UUID `json:"user_id"`
+	LastReadMessageID int64     `json:"last_read_message_id,omitempty"`
+}
+
+type Message struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	SenderID       uuid.UUID `json:"sender_id"`
+	Body           string    `json:"body"`
+}
+
+func ReadyMessageForJSON(sqlMessage database.Message) Message {
+	return Message{
+		ID:             sqlMessage.ID,
+		CreatedAt:      sqlMessage.CreatedAt,
+		ConversationID: sqlMessage.ConversationID,
+		SenderID:       sqlMessage.SenderID,
+		Body:           sqlMessage.Body,
+	}
+}
+
+var errNotParticipant = errors.New("Conversation not found")
+
+/*
+Load a conversation the caller takes part in. Everyone else gets the same
+404 as for a conversation that does not exist.
+*/
+func (cfg *APIConfig) getConversationForParticipant(ctx context.Context, pathID string, userID uuid.UUID) (database.Conversation, database.ConversationParticipant, error) {
+	conversationID, err := uuid.Parse(pathID)
+	if err != nil {
+		return database.Conversation{}, database.ConversationParticipant{}, errNotParticipant
+	}
+	participant, err := cfg.DBQueries.GetConversationParticipant(ctx, database.GetConversationParticipantParams{
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	if err != nil {
+		return database.Conversation{}, database.ConversationParticipant{}, errNotParticipant
+	}
+	sqlConversation, err := cfg.DBQueries.GetConversation(ctx, conversationID)
+	if err != nil {
+		return database.Conversation{}, database.ConversationParticipant{}, errNotParticipant
+	}
+	return sqlConversation, participant, nil
+}
+
+func (cfg *APIConfig) readyConversationForJSON(ctx context.Context, sqlConversation database.Conversation, caller database.ConversationParticipant) (Conversation, error) {
+	sqlParticipants, err := cfg.DBQueries.GetConversationParticipants(ctx, sqlConversation.ID)
+	if err != nil {
+		return Conversation{}, err
+	}
+	conversation := Conversation{
+		ID:           sqlConversation.ID,
+		CreatedAt:    sqlConversation.CreatedAt,
+		UpdatedAt:    sqlConversation.UpdatedAt,
+		Participants: []ConversationParticipant{},
+		Muted:        caller.Muted,
+	}
+	for _, sqlParticipant := range sqlParticipants {
+		conversation.Participants = append(conversation.Participants, ConversationParticipant{
+			UserID:            sqlParticipant.UserID,
+			LastReadMessageID: sqlParticipant.LastReadMessageID,
+		})
+	}
+	return conversation, nil
+}
+
+/*
+Start a conversation with one or more other users. Asking for a one-to-one
+conversation that already exists returns the existing one.
+*/
+func (cfg *APIConfig) CreateConversationHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		ParticipantIDs []uuid.UUID `json:"participant_ids"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	userIDs := []uuid.UUID{validatedID}
+	for _, participantID := range params.ParticipantIDs {
+		if !slices.Contains(userIDs, participantID) {
+			userIDs = append(userIDs, participantID)
+		}
+	}
+	if len(userIDs) < 2 {
+		respondWithError(response, 400, "A conversation needs at least one other participant")
+		return
+	}
+	if len(userIDs) > maxConversationParticipants {
+		respondWithError(response, 400, fmt.Sprintf("A conversation can have at most %d participants", maxConversationParticipants))
+		return
+	}
+	for _, userID := range userIDs[1:] {
+		if _, err := cfg.DBQueries.GetUser(request.Context(), userID); err != nil {
+			respondWithError(response, 404, "User not found")
+			return
+		}
+		if cfg.isBlockedBetween(request.Context(), validatedID, userID) {
+			respondWithError(response, 403, "Cannot message this user")
+			return
+		}
+	}
+
+	if len(userIDs) == 2 {
+		sqlConversation, err := cfg.DBQueries.GetDirectConversation(request.Context(), database.GetDirectConversationParams{
+			UserA: userIDs[0],
+			UserB: userIDs[1],
+		})
+		if err == nil {
+			cfg.respondWithConversation(response, request, sqlConversation, validatedID, 200)
+			return
+		}
+	}
+
+	sqlConversation, err := cfg.DBQueries.CreateConversation(request.Context(), uuid.NullUUID{UUID: validatedID, Valid: true})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to create conversation")
+		return
+	}
+	err = cfg.DBQueries.AddConversationParticipants(request.Context(), database.AddConversationParticipantsParams{
+		ConversationID: sqlConversation.ID,
+		UserIds:        userIDs,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to create conversation")
+		return
+	}
+	cfg.respondWithConversation(response, request, sqlConversation, validatedID, 201)
+}
+
+func (cfg *APIConfig) respondWithConversation(response http.ResponseWriter, request *http.Request, sqlConversation database.Conversation, userID uuid.UUID, code int) {
+	caller, err := cfg.DBQueries.GetConversationParticipant(request.Context(), database.GetConversationParticipantParams{
+		ConversationID: sqlConversation.ID,
+		UserID:         userID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get conversation")
+		return
+	}
+	respBody, err := cfg.readyConversationForJSON(request.Context(), sqlConversation, caller)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get conversation")
+		return
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, code, data)
+}
+
+func (cfg *APIConfig) GetConversationsHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if urlLimit := request.URL.Query().Get("limit"); len(urlLimit) != 0 {
+		if n, err := strconv.Atoi(urlLimit); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+
+	rows, err := cfg.DBQueries.GetConversationsForUser(request.Context(), database.GetConversationsForUserParams{
+		UserID: validatedID,
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get conversations")
+		return
+	}
+
+	respBody := []Conversation{}
+	for _, row := range rows {
+		conversation := Conversation{
+			ID:           row.ID,
+			CreatedAt:    row.CreatedAt,
+			UpdatedAt:    row.UpdatedAt,
+			Participants: []ConversationParticipant{},
+			Muted:        row.Muted,
+			UnreadCount:  row.UnreadCount,
+		}
+		for _, participantID := range row.ParticipantIds {
+			conversation.Participants = append(conversation.Participants, ConversationParticipant{UserID: participantID})
+		}
+		respBody = append(respBody, conversation)
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) GetConversationHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	sqlConversation, _, err := cfg.getConversationForParticipant(request.Context(), request.PathValue("conversationID"), validatedID)
+	if err != nil {
+		respondWithError(response, 404, err.Error())
+		return
+	}
+	cfg.respondWithConversation(response, request, sqlConversation, validatedID, 200)
+}
+
+func (cfg *APIConfig) GetMessagesHandler(response http.ResponseWriter, request *http.Request) {
+	type responseParameters struct {
+		Messages   []Message `json:"messages"`
+		NextCursor string    `json:"next_cursor,omitempty"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	sqlConversation, _, err := cfg.getConversationForParticipant(request.Context(), request.PathValue("conversationID"), validatedID)
+	if err != nil {
+		respondWithError(response, 404, err.Error())
+		return
+	}
+
+	query := request.URL.Query()
+	limit := 50
+	if urlLimit := query.Get("limit"); len(urlLimit) != 0 {
+		if n, err := strconv.Atoi(urlLimit); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+	// The cursor is the ID of the oldest message on the previous page.
+	beforeID := int64(math.MaxInt64)
+	if cursor := query.Get("cursor"); len(cursor) != 0 {
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n <= 0 {
+			respondWithError(response, 400, "Invalid cursor")
+			return
+		}
+		beforeID = n
+	}
+
+	sqlMessages, err := cfg.DBQueries.GetMessages(request.Context(), database.GetMessagesParams{
+		ConversationID: sqlConversation.ID,
+		BeforeID:       beforeID,
+		MaxResults:     int32(limit),
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get messages")
+		return
+	}
+
+	respBody := responseParameters{Messages: []Message{}}
+	for _, sqlMessage := range sqlMessages {
+		respBody.Messages = append(respBody.Messages, ReadyMessageForJSON(sqlMessage))
+	}
+	if len(sqlMessages) == limit {
+		respBody.NextCursor = strconv.FormatInt(sqlMessages[len(sqlMessages)-1].ID, 10)
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) PostMessageHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Body string `json:"body"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	sqlConversation, _, err := cfg.getConversationForParticipant(request.Context(), request.PathValue("conversationID"), validatedID)
+	if err != nil {
+		respondWithError(response, 404, err.Error())
+		return
+	}
+
+	// A block made after a direct conversation started still ends it.
+	sqlParticipants, err := cfg.DBQueries.GetConversationParticipants(request.Context(), sqlConversation.ID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get participants")
+		return
+	}
+	if len(sqlParticipants) == 2 {
+		for _, sqlParticipant := range sqlParticipants {
+			if sqlParticipant.UserID != validatedID && cfg.isBlockedBetween(request.Context(), validatedID, sqlParticipant.UserID) {
+				respondWithError(response, 403, "Cannot message this user")
+				return
+			}
+		}
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+	if len(params.Body) == 0 {
+		respondWithError(response, 400, "Message is empty")
+		return
+	}
+	if len(params.Body) > maxMessageLength {
+		respondWithError(response, 400, "Message is too long")
+		return
+	}
+
+	sqlMessage, err := cfg.DBQueries.CreateMessage(request.Context(), database.CreateMessageParams{
+		ConversationID: sqlConversation.ID,
+		SenderID:       validatedID,
+		Body:           params.Body,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to send message")
+		return
+	}
+	if err := cfg.DBQueries.TouchConversation(request.Context(), sqlConversation.ID); err != nil {
+		fmt.Println("Error: failed to update conversation -", err.Error())
+	}
+
+	respBody := ReadyMessageForJSON(sqlMessage)
+	cfg.deliverMessage(request.Context(), respBody)
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 201, data)
+}
+
+/*
+Push a new message to the other participants' live connections, and to their
+browsers unless they have muted the conversation.
+*/
+func (cfg *APIConfig) deliverMessage(ctx context.Context, message Message) {
+	sqlParticipants, err := cfg.DBQueries.GetConversationParticipants(ctx, message.ConversationID)
+	if err != nil {
+		fmt.Println("Error: failed to get conversation participants -", err.Error())
+		return
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	for _, sqlParticipant := range sqlParticipants {
+		if sqlParticipant.UserID == message.SenderID {
+			continue
+		}
+		err := cfg.Events.Publish(ctx, stream.Event{
+			Type:        "message.created",
+			AuthorID:    message.SenderID,
+			Data:        data,
+			RecipientID: uuid.NullUUID{UUID: sqlParticipant.UserID, Valid: true},
+		})
+		if err != nil {
+			fmt.Println("Error: failed to publish message -", err.Error())
+		}
+		if sqlParticipant.Muted {
+			continue
+		}
+		cfg.sendPush(sqlParticipant.UserID, func(ctx context.Context) pushPayload {
+			sender := cfg.userEmail(ctx, &message.SenderID)
+			body := "New message"
+			if sender.Valid {
+				body = "New message from " + sender.String
+			}
+			return pushPayload{Title: "Chirpy", Body: body, URL: "/app/", Data: message}
+		})
+	}
+}
+
+// Record a read receipt: everything up to and including message_id has been read.
+func (cfg *APIConfig) MarkConversationReadHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		MessageID int64 `json:"message_id"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	sqlConversation, _, err := cfg.getConversationForParticipant(request.Context(), request.PathValue("conversationID"), validatedID)
+	if err != nil {
+		respondWithError(response, 404, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	updated, err := cfg.DBQueries.MarkConversationRead(request.Context(), database.MarkConversationReadParams{
+		MessageID:      params.MessageID,
+		ConversationID: sqlConversation.ID,
+		UserID:         validatedID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to update read receipt")
+		return
+	}
+	if updated == 0 {
+		respondWithError(response, 404, "Message not found")
+		return
+	}
+	response.WriteHeader(204)
+}
+
+func (cfg *APIConfig) MuteConversationHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Muted bool `json:"muted"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	sqlConversation, _, err := cfg.getConversationForParticipant(request.Context(), request.PathValue("conversationID"), validatedID)
+	if err != nil {
+		respondWithError(response, 404, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	_, err = cfg.DBQueries.SetConversationMuted(request.Context(), database.SetConversationMutedParams{
+		ConversationID: sqlConversation.ID,
+		UserID:         validatedID,
+		Muted:          params.Muted,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to update conversation")
+		return
+	}
+	response.WriteHeader(204)
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/mail"
+)
+
+const (
+	digestBatchSize = 100
+	digestMaxItems  = 20
+)
+
+type digestItem struct {
+	Summary   string
+	ChirpBody string
+	// Shown instead of the body when the recipient hides sensitive content.
+	ContentWarning string
+	CreatedAt      time.Time
+}
+
+type digestData struct {
+	Email          string
+	Frequency      string
+	Items          []digestItem
+	More           int
+	AppURL         string
+	UnsubscribeURL string
+}
+
+var digestTextTemplate = texttemplate.Must(texttemplate.New("digest").Parse(
+	`Hi {{.Email}},
+
+Here is what happened on Chirpy since your last {{.Frequency}} digest:
+{{range .Items}}
+- {{.Summary}}{{if .ChirpBody}}: "{{.ChirpBody}}"{{else if .ContentWarning}} [{{.ContentWarning}}]{{end}}
+{{- end}}
+{{if .More}}
+...and {{.More}} more.
+{{end}}
+Catch up on Chirpy: {{.AppURL}}
+
+To stop receiving these emails, unsubscribe here: {{.UnsubscribeURL}}
+`))
+
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest").Parse(
+	`<html>
+  <body>
+    <p>Hi {{.Email}},</p>
+    <p>Here is what happened on Chirpy since your last {{.Frequency}} digest:</p>
+    <ul>
+      {{- range .Items}}
+      <li>{{.Summary}}{{if .ChirpBody}}: <q>{{.ChirpBody}}</q>{{else if .ContentWarning}} <em>[{{.ContentWarning}}]</em>{{end}}</li>
+      {{- end}}
+    </ul>
+    {{- if .More}}
+    <p>...and {{.More}} more.</p>
+    {{- end}}
+    <p><a href="{{.AppURL}}">Catch up on Chirpy</a></p>
+    <p style="font-size: small"><a href="{{.UnsubscribeURL}}">Unsubscribe from these emails</a></p>
+  </body>
+</html>
+`))
+
+func renderDigest(data digestData) (mail.Message, error) {
+	var text, html bytes.Buffer
+	if err := digestTextTemplate.Execute(&text, data); err != nil {
+		return mail.Message{}, err
+	}
+	if err := digestHTMLTemplate.Execute(&html, data); err != nil {
+		return mail.Message{}, err
+	}
+
+	count := len(data.Items) + data.More
+	subject := fmt.Sprintf("You have %d new notifications on Chirpy", count)
+	if count == 1 {
+		subject = "You have 1 new notification on Chirpy"
+	}
+	return mail.Message{
+		To:      data.Email,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// Scheduled job: email users who are due a digest of their unread notifications.
+func (cfg *APIConfig) SendEmailDigests(ctx context.Context) {
+	recipients, err := cfg.DBQueries.GetDigestRecipients(ctx, digestBatchSize)
+	if err != nil {
+		fmt.Println("Error: failed to get digest recipients -", err.Error())
+		return
+	}
+
+	sent := 0
+	for _, recipient := range recipients {
+		if err := cfg.sendEmailDigest(ctx, recipient); err != nil {
+			fmt.Printf("Error: failed to send digest to %s - %s\n", recipient.ID, err.Error())
+			continue
+		}
+		sent++
+	}
+	if sent > 0 {
+		fmt.Printf("Sent %d notification digests\n", sent)
+	}
+}
+
+func (cfg *APIConfig) sendEmailDigest(ctx context.Context, recipient database.GetDigestRecipientsRow) error {
+	// Fetch one extra row to tell whether there is more than fits in the email.
+	rows, err := cfg.DBQueries.GetDigestNotifications(ctx, database.GetDigestNotificationsParams{
+		UserID:    recipient.ID,
+		CreatedAt: recipient.LastDigestAt.Time,
+		Limit:     digestMaxItems + 1,
+	})
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	data := digestData{
+		Email:          recipient.Email,
+		Frequency:      recipient.DigestFrequency,
+		AppURL:         cfg.BaseURL + "/app/",
+		UnsubscribeURL: cfg.unsubscribeURL(recipient.ID),
+	}
+	if len(rows) > digestMaxItems {
+		rows = rows[:digestMaxItems]
+		unread, err := cfg.DBQueries.CountUnreadNotifications(ctx, recipient.ID)
+		if err == nil && unread > int64(len(rows)) {
+			data.More = int(unread) - len(rows)
+		}
+	}
+	for _, row := range rows {
+		item := digestItem{
+			Summary:   notificationSummary(row.Type, row.ActorEmail),
+			ChirpBody: row.ChirpBody.String,
+			CreatedAt: row.CreatedAt,
+		}
+		contentWarning, sensitive := effectiveContentFlags(row.ChirpContentWarning.String, row.ChirpSensitive.Bool, row.ChirpContentWarningOverride, row.ChirpSensitiveOverride)
+		if recipient.SensitiveContent != SensitiveExpand && (contentWarning != "" || sensitive) {
+			item.ChirpBody = ""
+			item.ContentWarning = contentWarning
+			if item.ContentWarning == "" {
+				item.ContentWarning = "sensitive content"
+			}
+		}
+		data.Items = append(data.Items, item)
+	}
+
+	msg, err := renderDigest(data)
+	if err != nil {
+		return err
+	}
+	// RFC 8058 one-click unsubscribe, so mail clients can offer their own button.
+	msg.Headers = map[string]string{
+		"List-Unsubscribe":      "<" + cfg.BaseURL + "/api/notifications/unsubscribe?token=" + url.QueryEscape(auth.MakeUnsubscribeToken(recipient.ID, cfg.Secret)) + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+	if err := cfg.Mailer.Send(ctx, msg); err != nil {
+		return err
+	}
+	return cfg.DBQueries.MarkDigestSent(ctx, recipient.ID)
+}
+
+// The link in the email body goes to a page that confirms by POST, so link scanners cannot unsubscribe people.
+func (cfg *APIConfig) unsubscribeURL(userID uuid.UUID) string {
+	return cfg.BaseURL + "/app/notifications/unsubscribe.html?token=" + url.QueryEscape(auth.MakeUnsubscribeToken(userID, cfg.Secret))
+}
+
+func (cfg *APIConfig) UnsubscribeHandler(response http.ResponseWriter, request *http.Request) {
+	userID, err := auth.ValidateUnsubscribeToken(request.URL.Query().Get("token"), cfg.Secret)
+	if err != nil {
+		respondWithError(response, 400, "Invalid unsubscribe link")
+		return
+	}
+	if err := cfg.DBQueries.DisableEmailDigest(request.Context(), userID); err != nil {
+		respondWithError(response, 500, "Server failed to unsubscribe")
+		return
+	}
+	response.WriteHeader(204)
+}
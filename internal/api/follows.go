@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+func (cfg *APIConfig) FollowUserHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	followeeID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+	if followeeID == validatedID {
+		respondWithError(response, 400, "Cannot follow yourself")
+		return
+	}
+	if _, err := cfg.DBQueries.GetUser(request.Context(), followeeID); err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+	if cfg.isBlockedBetween(request.Context(), validatedID, followeeID) {
+		respondWithError(response, 403, "Cannot follow this user")
+		return
+	}
+
+	followed, err := cfg.DBQueries.FollowUser(request.Context(), database.FollowUserParams{
+		FollowerID: validatedID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to follow user")
+		return
+	}
+
+	if followed > 0 {
+		cfg.emitWebhookEvent(request.Context(), "follow.created", []uuid.UUID{validatedID, followeeID}, map[string]uuid.UUID{
+			"follower_id": validatedID,
+			"followee_id": followeeID,
+		})
+		cfg.notify(request.Context(), followeeID, NotificationFollow, uuid.NullUUID{UUID: validatedID, Valid: true}, uuid.NullUUID{})
+	}
+	response.WriteHeader(204)
+}
+
+func (cfg *APIConfig) UnfollowUserHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	followeeID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+
+	if _, err := cfg.DBQueries.UnfollowUser(request.Context(), database.UnfollowUserParams{
+		FollowerID: validatedID,
+		FolloweeID: followeeID,
+	}); err != nil {
+		respondWithError(response, 500, "Server failed to unfollow user")
+		return
+	}
+	response.WriteHeader(204)
+}
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/stream"
+	"github.com/notsoexpert/gowebserver/internal/websocket"
+)
+
+const (
+	gatewayPingInterval = 30 * time.Second
+	gatewayReadTimeout  = 2 * gatewayPingInterval
+	gatewayEventBuffer  = 64
+	gatewayReplyBuffer  = 16
+	gatewayMaxTopics    = 50
+	gatewayCloseTimeout = 5 * time.Second
+)
+
+/*
+Tracks live WebSocket connections so they can be drained on shutdown.
+http.Server.Shutdown does not wait for hijacked connections, so the gateway
+asks each client to go away and waits for them itself.
+*/
+type Gateway struct {
+	mu       sync.Mutex
+	conns    map[*gatewayConn]struct{}
+	draining bool
+	wg       sync.WaitGroup
+}
+
+func NewGateway() *Gateway {
+	return &Gateway{conns: make(map[*gatewayConn]struct{})}
+}
+
+type gatewayConn struct {
+	ws     *websocket.Conn
+	userID uuid.UUID
+	hidden []uuid.UUID
+	// The viewer's sensitive content preference and muted words, read once like the block list.
+	sensitive string
+	muted     wordMatcher
+	mu        sync.Mutex
+	topics    map[string]struct{}
+	replies   chan []byte
+	done      chan struct{}
+	drain     chan struct{}
+}
+
+// Messages sent by clients.
+type gatewayRequest struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic"`
+}
+
+// Messages sent to clients.
+type gatewayMessage struct {
+	Type    string          `json:"type"`
+	Topic   string          `json:"topic,omitempty"`
+	Event   string          `json:"event,omitempty"`
+	ID      int64           `json:"id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+func (g *Gateway) register(conn *gatewayConn) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	g.conns[conn] = struct{}{}
+	g.wg.Add(1)
+	return true
+}
+
+func (g *Gateway) unregister(conn *gatewayConn) {
+	g.mu.Lock()
+	delete(g.conns, conn)
+	g.mu.Unlock()
+	g.wg.Done()
+}
+
+// Ask every connected client to go away and wait for them, or for ctx to expire.
+func (g *Gateway) Drain(ctx context.Context) {
+	g.mu.Lock()
+	g.draining = true
+	for conn := range g.conns {
+		close(conn.drain)
+	}
+	g.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+	}
+}
+
+/*
+Topics a client can subscribe to:
+
+	timeline:<userID>  chirps posted and deleted by a user
+	thread:<chirpID>   events about a single chirp and its replies
+	notifications      the caller's own notifications
+*/
+func validGatewayTopic(topic string) bool {
+	if topic == "notifications" {
+		return true
+	}
+	kind, id, ok := strings.Cut(topic, ":")
+	if !ok || (kind != "timeline" && kind != "thread") {
+		return false
+	}
+	_, err := uuid.Parse(id)
+	return err == nil
+}
+
+func (conn *gatewayConn) topicsFor(event stream.Event) []string {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	var topics []string
+	if event.RecipientID.Valid {
+		if _, ok := conn.topics["notifications"]; ok && event.RecipientID.UUID == conn.userID {
+			topics = append(topics, "notifications")
+		}
+		return topics
+	}
+	if slices.Contains(conn.hidden, event.AuthorID) {
+		return topics
+	}
+	if topic := "timeline:" + event.AuthorID.String(); hasKey(conn.topics, topic) {
+		topics = append(topics, topic)
+	}
+	if topic := "thread:" + event.ChirpID.String(); hasKey(conn.topics, topic) {
+		topics = append(topics, topic)
+	}
+	if event.ReplyToID.Valid {
+		if topic := "thread:" + event.ReplyToID.UUID.String(); hasKey(conn.topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+func hasKey(m map[string]struct{}, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// Queue a reply without blocking; a client that will not read its replies is cut off.
+func (conn *gatewayConn) reply(msg gatewayMessage) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return true
+	}
+	select {
+	case conn.replies <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+func (cfg *APIConfig) GatewayHandler(response http.ResponseWriter, request *http.Request) {
+	// Browsers cannot set headers on WebSocket requests, so the token may also come in the query.
+	token, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		token = request.URL.Query().Get("access_token")
+	}
+	validatedID, err := auth.ValidateJWT(token, cfg.Secret)
+	if err != nil {
+		respondWithError(response, 401, fmt.Sprintf("Unauthorized - %v", err.Error()))
+		return
+	}
+
+	// Block and mute lists are read once; changes apply from the next connection.
+	hidden, err := cfg.DBQueries.GetHiddenAuthorIDs(request.Context(), validatedID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get block list")
+		return
+	}
+
+	conn := &gatewayConn{
+		userID:    validatedID,
+		hidden:    hidden,
+		sensitive: cfg.sensitivePreference(request.Context(), uuid.NullUUID{UUID: validatedID, Valid: true}),
+		muted:     cfg.mutedWords(request.Context(), uuid.NullUUID{UUID: validatedID, Valid: true}),
+		topics:    make(map[string]struct{}),
+		replies:   make(chan []byte, gatewayReplyBuffer),
+		done:      make(chan struct{}),
+		drain:     make(chan struct{}),
+	}
+	if !cfg.Gateway.register(conn) {
+		respondWithError(response, 503, "Server shutting down")
+		return
+	}
+	defer cfg.Gateway.unregister(conn)
+
+	conn.ws, err = websocket.Upgrade(response, request)
+	if err != nil {
+		return
+	}
+	defer conn.ws.Close()
+
+	// Subscribe before reading so no event is missed between subscribe requests.
+	sub := cfg.Stream.Subscribe(gatewayEventBuffer)
+	defer sub.Close()
+
+	go conn.readLoop()
+	conn.writeLoop(sub)
+}
+
+func (conn *gatewayConn) readLoop() {
+	defer close(conn.done)
+
+	conn.ws.SetReadDeadline(time.Now().Add(gatewayReadTimeout))
+	conn.ws.PongHandler = func() {
+		conn.ws.SetReadDeadline(time.Now().Add(gatewayReadTimeout))
+	}
+
+	for {
+		_, data, err := conn.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.ws.SetReadDeadline(time.Now().Add(gatewayReadTimeout))
+
+		req := gatewayRequest{}
+		if err := json.Unmarshal(data, &req); err != nil {
+			if !conn.reply(gatewayMessage{Type: "error", Message: "Malformed message"}) {
+				conn.ws.WriteClose(websocket.ClosePolicy, "too many unread replies")
+				return
+			}
+			continue
+		}
+
+		var msg gatewayMessage
+		conn.mu.Lock()
+		switch {
+		case !validGatewayTopic(req.Topic):
+			msg = gatewayMessage{Type: "error", Topic: req.Topic, Message: "Unknown topic"}
+		case req.Type == "subscribe" && len(conn.topics) >= gatewayMaxTopics:
+			msg = gatewayMessage{Type: "error", Topic: req.Topic, Message: "Too many subscriptions"}
+		case req.Type == "subscribe":
+			conn.topics[req.Topic] = struct{}{}
+			msg = gatewayMessage{Type: "subscribed", Topic: req.Topic}
+		case req.Type == "unsubscribe":
+			delete(conn.topics, req.Topic)
+			msg = gatewayMessage{Type: "unsubscribed", Topic: req.Topic}
+		default:
+			msg = gatewayMessage{Type: "error", Message: "Unknown message type"}
+		}
+		conn.mu.Unlock()
+
+		if !conn.reply(msg) {
+			conn.ws.WriteClose(websocket.ClosePolicy, "too many unread replies")
+			return
+		}
+	}
+}
+
+func (conn *gatewayConn) writeLoop(sub *stream.Subscription) {
+	ping := time.NewTicker(gatewayPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-conn.drain:
+			conn.ws.WriteClose(websocket.CloseGoingAway, "server shutting down")
+			conn.waitForClose()
+			return
+		case data := <-conn.replies:
+			if err := conn.ws.WriteMessage(websocket.OpText, data); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				// The hub dropped us for falling behind.
+				conn.ws.WriteClose(websocket.CloseTryAgainLater, "client too slow")
+				conn.waitForClose()
+				return
+			}
+			topics := conn.topicsFor(event)
+			if len(topics) > 0 && conn.muted.mutesEvent(uuid.NullUUID{UUID: conn.userID, Valid: true}, event) {
+				topics = nil
+			}
+			if len(topics) > 0 {
+				event = collapseSensitiveEvent(event, uuid.NullUUID{UUID: conn.userID, Valid: true}, conn.sensitive)
+			}
+			for _, topic := range topics {
+				data, err := json.Marshal(gatewayMessage{
+					Type:  "event",
+					Topic: topic,
+					Event: event.Type,
+					ID:    event.ID,
+					Data:  event.Data,
+				})
+				if err != nil {
+					continue
+				}
+				if err := conn.ws.WriteMessage(websocket.OpText, data); err != nil {
+					return
+				}
+			}
+		case <-ping.C:
+			if err := conn.ws.WritePing(nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Give the client a moment to answer our close frame before dropping the connection.
+func (conn *gatewayConn) waitForClose() {
+	conn.ws.SetReadDeadline(time.Now().Add(gatewayCloseTimeout))
+	<-conn.done
+}
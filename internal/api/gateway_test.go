@@ -0,0 +1,35 @@
+package api
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/stream"
+)
+
+func TestGatewayTopicsFor(t *testing.T) {
+	author := uuid.New()
+	parent := uuid.New()
+	reply := uuid.New()
+	conn := &gatewayConn{
+		topics: map[string]struct{}{
+			"thread:" + parent.String(): {},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		event    stream.Event
+		expected []string
+	}{
+		{"parent event", stream.Event{ChirpID: parent, AuthorID: author}, []string{"thread:" + parent.String()}},
+		{"reply event", stream.Event{ChirpID: reply, AuthorID: author, ReplyToID: uuid.NullUUID{UUID: parent, Valid: true}}, []string{"thread:" + parent.String()}},
+		{"unrelated event", stream.Event{ChirpID: reply, AuthorID: author}, nil},
+	}
+	for _, c := range cases {
+		if topics := conn.topicsFor(c.event); !slices.Equal(topics, c.expected) {
+			t.Errorf(`%s: topicsFor = %v, expected %v`, c.name, topics, c.expected)
+		}
+	}
+}
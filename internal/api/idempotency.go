@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	maxIdempotentBodyBytes   = 1 << 20
+	DefaultIdempotencyWindow = 24 * time.Hour
+)
+
+// Records the response on its way out so it can be stored for replay.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = 200
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func requestFingerprint(request *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", request.Method, request.URL.Path)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Failures the client is expected to retry free the key rather than being replayed.
+func storeIdempotentResponse(status int) bool {
+	switch {
+	case status >= 500, status == 401, status == 403, status == 429:
+		return false
+	default:
+		return status != 0
+	}
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for _, c := range key {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Honour an Idempotency-Key header on a route. The first request with a key
+runs as usual and its response is kept for IdempotencyWindow; a retry with
+the same key and body gets that response back, and one with a different body
+is refused with 422. Keys belong to the caller, so two users cannot collide.
+*/
+func (cfg *APIConfig) Idempotent(route string, next http.Handler) http.Handler {
+	return cfg.idempotent(route, true, next)
+}
+
+/*
+Like Idempotent, but keys are shared by every caller on the route. Meant for
+webhook receivers, whose senders may retry from another address and prove
+who they are inside the handler instead.
+*/
+func (cfg *APIConfig) IdempotentWebhook(route string, next http.Handler) http.Handler {
+	return cfg.idempotent(route, false, next)
+}
+
+func (cfg *APIConfig) idempotent(route string, perCaller bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			respondWithError(w, 400, "Invalid Idempotency-Key")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			respondWithError(w, 400, "Malformed request")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := route
+		if perCaller {
+			callerKey, _ := cfg.callerKey(r)
+			scope += ":" + callerKey
+		}
+		fingerprint := requestFingerprint(r, body)
+		window := cfg.IdempotencyWindow
+		if window <= 0 {
+			window = DefaultIdempotencyWindow
+		}
+
+		claim, err := cfg.DBQueries.ClaimIdempotencyKey(r.Context(), database.ClaimIdempotencyKeyParams{
+			Scope:       scope,
+			Key:         key,
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().UTC().Add(window),
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			cfg.replayIdempotentResponse(w, r, scope, key, fingerprint)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Server failed to check Idempotency-Key")
+			return
+		}
+
+		// The client may have hung up; the outcome still has to be recorded.
+		// Both writes are tied to our claim, in case the lease ran out and
+		// another request has taken the key over.
+		ctx := context.WithoutCancel(r.Context())
+		release := func() {
+			if err := cfg.DBQueries.ReleaseIdempotencyKey(ctx, database.ReleaseIdempotencyKeyParams{
+				Scope:     scope,
+				Key:       key,
+				CreatedAt: claim.CreatedAt,
+			}); err != nil {
+				fmt.Println("Error: failed to release idempotency key -", err.Error())
+			}
+		}
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				release()
+				panic(recovered)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if !storeIdempotentResponse(recorder.status) {
+			release()
+			return
+		}
+		contentType := w.Header().Get("Content-Type")
+		err = cfg.DBQueries.SaveIdempotentResponse(ctx, database.SaveIdempotentResponseParams{
+			Scope:        scope,
+			Key:          key,
+			CreatedAt:    claim.CreatedAt,
+			StatusCode:   sql.NullInt32{Int32: int32(recorder.status), Valid: true},
+			ContentType:  sql.NullString{String: contentType, Valid: contentType != ""},
+			ResponseBody: recorder.body.Bytes(),
+		})
+		if err != nil {
+			fmt.Println("Error: failed to save idempotent response -", err.Error())
+		}
+	})
+}
+
+func (cfg *APIConfig) replayIdempotentResponse(w http.ResponseWriter, r *http.Request, scope, key, fingerprint string) {
+	stored, err := cfg.DBQueries.GetIdempotencyKey(r.Context(), database.GetIdempotencyKeyParams{
+		Scope: scope,
+		Key:   key,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released between our claim and this lookup; the client can try again.
+		respondWithError(w, 409, "A request with this Idempotency-Key is in progress")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Server failed to check Idempotency-Key")
+		return
+	}
+	if stored.Fingerprint != fingerprint {
+		respondWithError(w, 422, "Idempotency-Key was already used for a different request")
+		return
+	}
+	if !stored.StatusCode.Valid {
+		respondWithError(w, 409, "A request with this Idempotency-Key is in progress")
+		return
+	}
+
+	if stored.ContentType.Valid {
+		w.Header().Set("Content-Type", stored.ContentType.String)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(int(stored.StatusCode.Int32))
+	w.Write(stored.ResponseBody)
+}
+
+// Scheduled job: forget idempotency keys whose window has passed.
+func (cfg *APIConfig) PruneIdempotencyKeys(ctx context.Context) {
+	if err := cfg.DBQueries.DeleteExpiredIdempotencyKeys(ctx); err != nil {
+		fmt.Println("Error: failed to prune idempotency keys -", err.Error())
+	}
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// Run job once immediately and then every interval until ctx is canceled.
+func RunEvery(ctx context.Context, interval time.Duration, job func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
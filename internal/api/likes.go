@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+func (cfg *APIConfig) LikeChirpHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(response, 404, "Chirp not found")
+		return
+	}
+	sqlChirp, err := cfg.DBQueries.GetVisibleChirp(request.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: uuid.NullUUID{UUID: validatedID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(response, 404, "Chirp not found")
+		return
+	}
+
+	liked, err := cfg.DBQueries.LikeChirp(request.Context(), database.LikeChirpParams{
+		UserID:  validatedID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to like chirp")
+		return
+	}
+
+	if liked > 0 && sqlChirp.UserID.Valid {
+		cfg.notify(request.Context(), sqlChirp.UserID.UUID, NotificationLike,
+			uuid.NullUUID{UUID: validatedID, Valid: true}, uuid.NullUUID{UUID: chirpID, Valid: true})
+	}
+	response.WriteHeader(204)
+}
+
+func (cfg *APIConfig) UnlikeChirpHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(response, 404, "Chirp not found")
+		return
+	}
+
+	_, err = cfg.DBQueries.UnlikeChirp(request.Context(), database.UnlikeChirpParams{
+		UserID:  validatedID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to unlike chirp")
+		return
+	}
+	response.WriteHeader(204)
+}
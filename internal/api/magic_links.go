@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/mail"
+)
+
+const (
+	magicLinkLifetime    = 15 * time.Minute
+	magicLinkSendTimeout = time.Minute
+	magicLinkCooldown    = time.Minute
+)
+
+/*
+Email a single-use sign-in link. The response is the same whether or not the
+email belongs to an account, so the endpoint cannot be used to probe for users:
+the link is created and sent in the background, and any failure is only logged.
+An account is sent at most one link per magicLinkCooldown.
+*/
+func (cfg *APIConfig) MagicLinkHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	sqlUser, err := cfg.DBQueries.GetUserByEmail(request.Context(), params.Email)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(request.Context()), magicLinkSendTimeout)
+		go func() {
+			defer cancel()
+			if err := cfg.sendMagicLink(ctx, sqlUser); err != nil {
+				fmt.Println("Error: failed to send sign-in link -", err.Error())
+			}
+		}()
+	}
+	response.WriteHeader(202)
+}
+
+func (cfg *APIConfig) sendMagicLink(ctx context.Context, sqlUser database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	created, err := cfg.DBQueries.CreateMagicLink(ctx, database.CreateMagicLinkParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    sqlUser.ID,
+		ExpiresAt: now.Add(magicLinkLifetime),
+		SentAfter: now.Add(-magicLinkCooldown),
+	})
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		// A link went out moments ago; that one is still good.
+		return nil
+	}
+
+	link := cfg.BaseURL + "/app/login/magic.html?token=" + url.QueryEscape(token)
+	return cfg.Mailer.Send(ctx, mail.Message{
+		To:      sqlUser.Email,
+		Subject: "Your Chirpy sign-in link",
+		Text: fmt.Sprintf("Click the link below to sign in to Chirpy. It expires in %d minutes and can only be used once.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			int(magicLinkLifetime.Minutes()), link),
+	})
+}
+
+// Exchange a sign-in link token for the same response as a password login.
+func (cfg *APIConfig) ConsumeMagicLinkHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	sqlLink, err := cfg.DBQueries.ConsumeMagicLink(request.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		cfg.audit(request.Context(), AuditLoginFailed, uuid.NullUUID{}, uuid.NullUUID{}, "invalid magic link")
+		respondWithError(response, 401, "Invalid or already used link")
+		return
+	}
+	if time.Now().After(sqlLink.ExpiresAt) {
+		cfg.audit(request.Context(), AuditLoginFailed, uuid.NullUUID{}, userIDOf(sqlLink.UserID), "expired magic link")
+		respondWithError(response, 401, "Link expired")
+		return
+	}
+
+	sqlUser, err := cfg.DBQueries.GetUser(request.Context(), sqlLink.UserID)
+	if err != nil {
+		respondWithError(response, 401, "Invalid or already used link")
+		return
+	}
+
+	cfg.respondWithLogin(response, request, sqlUser)
+}
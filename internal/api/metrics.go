@@ -3,6 +3,8 @@ package api
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/google/uuid"
 )
 
 func (cfg *APIConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
@@ -30,6 +32,7 @@ func (cfg *APIConfig) ResetRequestsHandler(response http.ResponseWriter, request
 		response.WriteHeader(403)
 		return
 	}
+	cfg.audit(request.Context(), AuditAdminReset, cfg.requestUserID(request), uuid.NullUUID{}, "")
 	response.WriteHeader(200)
 	cfg.fileserverHits.Store(0)
 	cfg.DBQueries.Reset(request.Context())
@@ -0,0 +1,523 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+var reportReasons = []string{
+	"spam",
+	"harassment",
+	"hate",
+	"violence",
+	"sexual",
+	"self_harm",
+	"misinformation",
+	"other",
+}
+
+// Actions a moderator can resolve a case with. Each one is written to the moderation log.
+const (
+	ModerationDismiss     = "dismiss"
+	ModerationHideChirp   = "hide_chirp"
+	ModerationDeleteChirp = "delete_chirp"
+	ModerationWarnUser    = "warn_user"
+	ModerationSuspendUser = "suspend_user"
+	ModerationClaim       = "claim"
+)
+
+var moderationResolutions = []string{
+	ModerationDismiss,
+	ModerationHideChirp,
+	ModerationDeleteChirp,
+	ModerationWarnUser,
+	ModerationSuspendUser,
+}
+
+const maxReportDetailsLength = 1000
+
+type ChirpReport struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CaseID    uuid.UUID `json:"case_id"`
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details,omitempty"`
+}
+
+func ReadyChirpReportForJSON(sqlReport database.ChirpReport) ChirpReport {
+	return ChirpReport{
+		ID:        sqlReport.ID,
+		CreatedAt: sqlReport.CreatedAt,
+		CaseID:    sqlReport.CaseID,
+		Reason:    sqlReport.Reason,
+		Details:   sqlReport.Details,
+	}
+}
+
+type ModerationCase struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ChirpID    *uuid.UUID `json:"chirp_id"`
+	SubjectID  *uuid.UUID `json:"subject_id"`
+	ChirpBody  string     `json:"chirp_body"`
+	Status     string     `json:"status"`
+	ClaimedBy  *uuid.UUID `json:"claimed_by"`
+	ClaimedAt  *time.Time `json:"claimed_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	Resolution string     `json:"resolution,omitempty"`
+	// Why the spam filter held the chirp, for cases not opened by a report.
+	FlaggedReason string        `json:"flagged_reason,omitempty"`
+	ReportCount   int64         `json:"report_count,omitempty"`
+	Reports       []ChirpReport `json:"reports,omitempty"`
+}
+
+func ReadyModerationCaseForJSON(sqlCase database.ModerationCase) ModerationCase {
+	return ModerationCase{
+		ID:            sqlCase.ID,
+		CreatedAt:     sqlCase.CreatedAt,
+		UpdatedAt:     sqlCase.UpdatedAt,
+		ChirpID:       nullUUIDPtr(sqlCase.ChirpID),
+		SubjectID:     nullUUIDPtr(sqlCase.SubjectID),
+		ChirpBody:     sqlCase.ChirpBody,
+		Status:        sqlCase.Status,
+		ClaimedBy:     nullUUIDPtr(sqlCase.ClaimedBy),
+		ClaimedAt:     nullTimePtr(sqlCase.ClaimedAt),
+		ResolvedAt:    nullTimePtr(sqlCase.ResolvedAt),
+		Resolution:    sqlCase.Resolution.String,
+		FlaggedReason: sqlCase.FlaggedReason,
+	}
+}
+
+type ModerationLogEntry struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ModeratorID uuid.UUID  `json:"moderator_id"`
+	Action      string     `json:"action"`
+	CaseID      *uuid.UUID `json:"case_id,omitempty"`
+	ChirpID     *uuid.UUID `json:"chirp_id,omitempty"`
+	SubjectID   *uuid.UUID `json:"subject_id,omitempty"`
+	Note        string     `json:"note,omitempty"`
+}
+
+func ReadyModerationLogEntryForJSON(sqlEntry database.ModerationLog) ModerationLogEntry {
+	return ModerationLogEntry{
+		ID:          sqlEntry.ID,
+		CreatedAt:   sqlEntry.CreatedAt,
+		ModeratorID: sqlEntry.ModeratorID,
+		Action:      sqlEntry.Action,
+		CaseID:      nullUUIDPtr(sqlEntry.CaseID),
+		ChirpID:     nullUUIDPtr(sqlEntry.ChirpID),
+		SubjectID:   nullUUIDPtr(sqlEntry.SubjectID),
+		Note:        sqlEntry.Note,
+	}
+}
+
+// The log is the record of what moderators did, so failing to write it is loud.
+func (cfg *APIConfig) logModeration(ctx context.Context, entry database.AppendModerationLogParams) error {
+	cfg.audit(ctx, AuditModerationPrefix+entry.Action, userIDOf(entry.ModeratorID), entry.SubjectID, entry.Note)
+	_, err := cfg.DBQueries.AppendModerationLog(ctx, entry)
+	if err != nil {
+		fmt.Println("Error: failed to write moderation log -", err.Error())
+	}
+	return err
+}
+
+func (cfg *APIConfig) ReportChirpHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(request.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(response, 404, "Chirp not found")
+		return
+	}
+	sqlChirp, err := cfg.DBQueries.GetVisibleChirp(request.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: uuid.NullUUID{UUID: validatedID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(response, 404, "Chirp not found")
+		return
+	}
+	if sqlChirp.UserID.UUID == validatedID {
+		respondWithError(response, 400, "Cannot report your own chirp")
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+	if !slices.Contains(reportReasons, params.Reason) {
+		respondWithError(response, 400, fmt.Sprintf("Unknown report reason %q", params.Reason))
+		return
+	}
+	if len(params.Details) > maxReportDetailsLength {
+		respondWithError(response, 400, "Report details are too long")
+		return
+	}
+
+	sqlCase, err := cfg.DBQueries.OpenModerationCase(request.Context(), database.OpenModerationCaseParams{
+		ChirpID:   uuid.NullUUID{UUID: sqlChirp.ID, Valid: true},
+		SubjectID: sqlChirp.UserID,
+		ChirpBody: sqlChirp.Body,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to record report")
+		return
+	}
+	sqlReport, err := cfg.DBQueries.CreateChirpReport(request.Context(), database.CreateChirpReportParams{
+		CaseID:     sqlCase.ID,
+		ReporterID: validatedID,
+		Reason:     params.Reason,
+		Details:    params.Details,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(response, 409, "Chirp already reported")
+		return
+	}
+	if err != nil {
+		respondWithError(response, 500, "Server failed to record report")
+		return
+	}
+
+	data, encErr := json.Marshal(ReadyChirpReportForJSON(sqlReport))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 201, data)
+}
+
+func (cfg *APIConfig) ListModerationCasesHandler(response http.ResponseWriter, request *http.Request) {
+	status := request.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+	if !slices.Contains([]string{"open", "claimed", "resolved"}, status) {
+		respondWithError(response, 400, "Status must be open, claimed or resolved")
+		return
+	}
+
+	limit := 100
+	if urlLimit := request.URL.Query().Get("limit"); len(urlLimit) != 0 {
+		if n, err := strconv.Atoi(urlLimit); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+
+	rows, err := cfg.DBQueries.ListModerationCases(request.Context(), database.ListModerationCasesParams{
+		Status: status,
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get moderation queue")
+		return
+	}
+
+	respBody := []ModerationCase{}
+	for _, row := range rows {
+		moderationCase := ReadyModerationCaseForJSON(database.ModerationCase{
+			ID:            row.ID,
+			CreatedAt:     row.CreatedAt,
+			UpdatedAt:     row.UpdatedAt,
+			ChirpID:       row.ChirpID,
+			SubjectID:     row.SubjectID,
+			ChirpBody:     row.ChirpBody,
+			Status:        row.Status,
+			ClaimedBy:     row.ClaimedBy,
+			ClaimedAt:     row.ClaimedAt,
+			ResolvedAt:    row.ResolvedAt,
+			Resolution:    row.Resolution,
+			FlaggedReason: row.FlaggedReason,
+		})
+		moderationCase.ReportCount = row.ReportCount
+		respBody = append(respBody, moderationCase)
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) GetModerationCaseHandler(response http.ResponseWriter, request *http.Request) {
+	caseID, err := uuid.Parse(request.PathValue("caseID"))
+	if err != nil {
+		respondWithError(response, 404, "Case not found")
+		return
+	}
+	sqlCase, err := cfg.DBQueries.GetModerationCase(request.Context(), caseID)
+	if err != nil {
+		respondWithError(response, 404, "Case not found")
+		return
+	}
+	sqlReports, err := cfg.DBQueries.GetChirpReportsForCase(request.Context(), caseID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get reports")
+		return
+	}
+
+	respBody := ReadyModerationCaseForJSON(sqlCase)
+	respBody.ReportCount = int64(len(sqlReports))
+	for _, sqlReport := range sqlReports {
+		respBody.Reports = append(respBody.Reports, ReadyChirpReportForJSON(sqlReport))
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+// Take an open case so that no other moderator works on it at the same time.
+func (cfg *APIConfig) ClaimModerationCaseHandler(response http.ResponseWriter, request *http.Request) {
+	moderatorID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	caseID, err := uuid.Parse(request.PathValue("caseID"))
+	if err != nil {
+		respondWithError(response, 404, "Case not found")
+		return
+	}
+	if _, err := cfg.DBQueries.GetModerationCase(request.Context(), caseID); err != nil {
+		respondWithError(response, 404, "Case not found")
+		return
+	}
+
+	sqlCase, err := cfg.DBQueries.ClaimModerationCase(request.Context(), database.ClaimModerationCaseParams{
+		ID:        caseID,
+		ClaimedBy: uuid.NullUUID{UUID: moderatorID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(response, 409, "Case is not open")
+		return
+	}
+	if err != nil {
+		respondWithError(response, 500, "Server failed to claim case")
+		return
+	}
+	cfg.logModeration(request.Context(), database.AppendModerationLogParams{
+		ModeratorID: moderatorID,
+		Action:      ModerationClaim,
+		CaseID:      uuid.NullUUID{UUID: sqlCase.ID, Valid: true},
+		ChirpID:     sqlCase.ChirpID,
+		SubjectID:   sqlCase.SubjectID,
+	})
+
+	data, encErr := json.Marshal(ReadyModerationCaseForJSON(sqlCase))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+/*
+Resolve a case the caller has claimed, applying one of the moderation
+actions to the reported chirp or its author.
+*/
+func (cfg *APIConfig) ResolveModerationCaseHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Action       string `json:"action"`
+		Note         string `json:"note"`
+		SuspendHours int    `json:"suspend_hours"`
+	}
+
+	moderatorID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	caseID, err := uuid.Parse(request.PathValue("caseID"))
+	if err != nil {
+		respondWithError(response, 404, "Case not found")
+		return
+	}
+	sqlCase, err := cfg.DBQueries.GetModerationCase(request.Context(), caseID)
+	if err != nil {
+		respondWithError(response, 404, "Case not found")
+		return
+	}
+	if sqlCase.Status != "claimed" || sqlCase.ClaimedBy.UUID != moderatorID {
+		respondWithError(response, 409, "Claim the case before resolving it")
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+	if !slices.Contains(moderationResolutions, params.Action) {
+		respondWithError(response, 400, fmt.Sprintf("Unknown action %q", params.Action))
+		return
+	}
+	if params.Action == ModerationSuspendUser && params.SuspendHours <= 0 {
+		respondWithError(response, 400, "suspend_hours must be positive")
+		return
+	}
+	if (params.Action == ModerationHideChirp || params.Action == ModerationDeleteChirp) && !sqlCase.ChirpID.Valid {
+		respondWithError(response, 409, "Chirp has already been deleted")
+		return
+	}
+	if params.Action == ModerationWarnUser || params.Action == ModerationSuspendUser {
+		if !sqlCase.SubjectID.Valid {
+			respondWithError(response, 409, "User no longer exists")
+			return
+		}
+		if outranks, err := cfg.outranks(request.Context(), moderatorID, sqlCase.SubjectID.UUID); err != nil {
+			respondWithError(response, 500, "Server failed to check roles")
+			return
+		} else if !outranks {
+			respondWithError(response, 403, "Cannot act against a user with an equal or higher role")
+			return
+		}
+	}
+
+	if err := cfg.applyModerationAction(request.Context(), sqlCase, params.Action, params.Note, time.Duration(params.SuspendHours)*time.Hour); err != nil {
+		respondWithError(response, 500, "Server failed to apply action")
+		return
+	}
+
+	sqlCase, err = cfg.DBQueries.ResolveModerationCase(request.Context(), database.ResolveModerationCaseParams{
+		ID:         caseID,
+		ClaimedBy:  uuid.NullUUID{UUID: moderatorID, Valid: true},
+		Resolution: sql.NullString{String: params.Action, Valid: true},
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to resolve case")
+		return
+	}
+	note := params.Note
+	if params.Action == ModerationSuspendUser {
+		note = fmt.Sprintf("suspended for %d hours. %s", params.SuspendHours, params.Note)
+	}
+	cfg.logModeration(request.Context(), database.AppendModerationLogParams{
+		ModeratorID: moderatorID,
+		Action:      params.Action,
+		CaseID:      uuid.NullUUID{UUID: sqlCase.ID, Valid: true},
+		ChirpID:     sqlCase.ChirpID,
+		SubjectID:   sqlCase.SubjectID,
+		Note:        note,
+	})
+
+	data, encErr := json.Marshal(ReadyModerationCaseForJSON(sqlCase))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) applyModerationAction(ctx context.Context, sqlCase database.ModerationCase, action, note string, suspendFor time.Duration) error {
+	switch action {
+	case ModerationDismiss:
+		if sqlCase.FlaggedReason != "" && sqlCase.ChirpID.Valid {
+			return cfg.releaseHeldChirp(ctx, sqlCase.ChirpID.UUID)
+		}
+	case ModerationHideChirp:
+		return cfg.DBQueries.HideChirp(ctx, sqlCase.ChirpID.UUID)
+	case ModerationDeleteChirp:
+		sqlChirp, err := cfg.DBQueries.GetChirp(ctx, sqlCase.ChirpID.UUID)
+		if err != nil {
+			return err
+		}
+		return cfg.removeChirp(ctx, ReadyChirpForJSON(sqlChirp))
+	case ModerationWarnUser:
+		cfg.notify(ctx, sqlCase.SubjectID.UUID, NotificationModerationWarning, uuid.NullUUID{}, sqlCase.ChirpID)
+	case ModerationSuspendUser:
+		reason := note
+		if reason == "" {
+			reason = fmt.Sprintf("Moderation case %s", sqlCase.ID)
+		}
+		return cfg.setAccountState(ctx, sqlCase.SubjectID.UUID, AccountSuspended, reason, time.Now().Add(suspendFor))
+	}
+	return nil
+}
+
+func (cfg *APIConfig) GetModerationLogHandler(response http.ResponseWriter, request *http.Request) {
+	type responseParameters struct {
+		Entries    []ModerationLogEntry `json:"entries"`
+		NextCursor string               `json:"next_cursor,omitempty"`
+	}
+
+	query := request.URL.Query()
+	limit := 100
+	if urlLimit := query.Get("limit"); len(urlLimit) != 0 {
+		if n, err := strconv.Atoi(urlLimit); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+	beforeID := int64(math.MaxInt64)
+	if cursor := query.Get("cursor"); len(cursor) != 0 {
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n <= 0 {
+			respondWithError(response, 400, "Invalid cursor")
+			return
+		}
+		beforeID = n
+	}
+	var subjectID uuid.NullUUID
+	if urlSubjectID := query.Get("subject_id"); len(urlSubjectID) != 0 {
+		id, err := uuid.Parse(urlSubjectID)
+		if err != nil {
+			respondWithError(response, 400, "Invalid subject ID")
+			return
+		}
+		subjectID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	sqlEntries, err := cfg.DBQueries.GetModerationLog(request.Context(), database.GetModerationLogParams{
+		BeforeID:   beforeID,
+		SubjectID:  subjectID,
+		MaxResults: int32(limit),
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get moderation log")
+		return
+	}
+
+	respBody := responseParameters{Entries: []ModerationLogEntry{}}
+	for _, sqlEntry := range sqlEntries {
+		respBody.Entries = append(respBody.Entries, ReadyModerationLogEntryForJSON(sqlEntry))
+	}
+	if len(sqlEntries) == limit {
+		respBody.NextCursor = strconv.FormatInt(sqlEntries[len(sqlEntries)-1].ID, 10)
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
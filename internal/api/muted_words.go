@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/stream"
+)
+
+const (
+	maxMutedWords        = 200
+	maxMutedPhraseLength = 100
+)
+
+type MutedWord struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Phrase    string     `json:"phrase"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func ReadyMutedWordForJSON(sqlMutedWord database.MutedWord) MutedWord {
+	return MutedWord{
+		ID:        sqlMutedWord.ID,
+		CreatedAt: sqlMutedWord.CreatedAt,
+		Phrase:    sqlMutedWord.Phrase,
+		ExpiresAt: nullTimePtr(sqlMutedWord.ExpiresAt),
+	}
+}
+
+/*
+The viewer's muted words as a matcher. Like the block list it is read once
+per request or connection, and a viewer whose list cannot be read gets an
+empty matcher rather than an error.
+*/
+func (cfg *APIConfig) mutedWords(ctx context.Context, viewerID uuid.NullUUID) wordMatcher {
+	if !viewerID.Valid {
+		return wordMatcher{}
+	}
+	sqlMutedWords, err := cfg.DBQueries.GetMutedWords(ctx, viewerID.UUID)
+	if err != nil {
+		fmt.Println("Error: failed to get muted words -", err.Error())
+		return wordMatcher{}
+	}
+	phrases := make([]string, 0, len(sqlMutedWords))
+	for _, sqlMutedWord := range sqlMutedWords {
+		phrases = append(phrases, sqlMutedWord.Phrase)
+	}
+	return newWordMatcher(phrases)
+}
+
+// Whether a chirp by someone else contains one of the viewer's muted words.
+func (matcher wordMatcher) mutes(viewerID uuid.NullUUID, authorID uuid.UUID, body string) bool {
+	if viewerID.Valid && viewerID.UUID == authorID {
+		return false
+	}
+	return matcher.matches(body)
+}
+
+func (matcher wordMatcher) mutesEvent(viewerID uuid.NullUUID, event stream.Event) bool {
+	if len(matcher.phrases) == 0 || event.Type != "chirp.created" {
+		return false
+	}
+	var chirp Chirp
+	if err := json.Unmarshal(event.Data, &chirp); err != nil {
+		return false
+	}
+	return matcher.mutes(viewerID, chirp.UserID, chirp.Body)
+}
+
+func (cfg *APIConfig) GetMutedWordsHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsRead)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	sqlMutedWords, err := cfg.DBQueries.GetMutedWords(request.Context(), validatedID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get muted words")
+		return
+	}
+	respBody := []MutedWord{}
+	for _, sqlMutedWord := range sqlMutedWords {
+		respBody = append(respBody, ReadyMutedWordForJSON(sqlMutedWord))
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) CreateMutedWordHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Phrase    string     `json:"phrase"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	// Stored the way the matcher compares, so duplicates collapse into one entry.
+	phrase := strings.Join(phraseWords(params.Phrase), " ")
+	if len(phrase) == 0 {
+		respondWithError(response, 400, "Phrase is required")
+		return
+	}
+	if len(phrase) > maxMutedPhraseLength {
+		respondWithError(response, 400, "Phrase is too long")
+		return
+	}
+	var expiresAt sql.NullTime
+	if params.ExpiresAt != nil {
+		if !params.ExpiresAt.After(time.Now()) {
+			respondWithError(response, 400, "expires_at must be in the future")
+			return
+		}
+		expiresAt = sql.NullTime{Time: *params.ExpiresAt, Valid: true}
+	}
+
+	count, err := cfg.DBQueries.CountMutedWords(request.Context(), validatedID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to mute phrase")
+		return
+	}
+	if count >= maxMutedWords {
+		respondWithError(response, 400, fmt.Sprintf("Cannot mute more than %d phrases", maxMutedWords))
+		return
+	}
+
+	sqlMutedWord, err := cfg.DBQueries.CreateMutedWord(request.Context(), database.CreateMutedWordParams{
+		UserID:    validatedID,
+		Phrase:    phrase,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to mute phrase")
+		return
+	}
+
+	data, encErr := json.Marshal(ReadyMutedWordForJSON(sqlMutedWord))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 201, data)
+}
+
+func (cfg *APIConfig) DeleteMutedWordHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, err := cfg.authenticate(request, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	mutedWordID, err := uuid.Parse(request.PathValue("mutedWordID"))
+	if err != nil {
+		respondWithError(response, 404, "Muted word not found")
+		return
+	}
+	deleted, err := cfg.DBQueries.DeleteMutedWord(request.Context(), database.DeleteMutedWordParams{
+		ID:     mutedWordID,
+		UserID: validatedID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to unmute phrase")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(response, 404, "Muted word not found")
+		return
+	}
+	response.WriteHeader(204)
+}
+
+// Scheduled job: forget muted words that have expired.
+func (cfg *APIConfig) PruneMutedWords(ctx context.Context) {
+	if err := cfg.DBQueries.DeleteExpiredMutedWords(ctx); err != nil {
+		fmt.Println("Error: failed to prune muted words -", err.Error())
+	}
+}
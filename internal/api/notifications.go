@@ -0,0 +1,394 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/stream"
+)
+
+const (
+	NotificationMention   = "mention"
+	NotificationReply     = "reply"
+	NotificationFollow    = "follow"
+	NotificationLike      = "like"
+	NotificationChirpyRed = "chirpy_red"
+	// Sent by moderators. Not in notificationTypes, so users cannot turn it off.
+	NotificationModerationWarning = "moderation_warning"
+)
+
+var notificationTypes = []string{
+	NotificationMention,
+	NotificationReply,
+	NotificationFollow,
+	NotificationLike,
+	NotificationChirpyRed,
+}
+
+type Notification struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Type      string     `json:"type"`
+	ActorID   *uuid.UUID `json:"actor_id,omitempty"`
+	ChirpID   *uuid.UUID `json:"chirp_id,omitempty"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+func nullUUIDPtr(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	return &id.UUID
+}
+
+func nullUUIDOf(id *uuid.UUID) uuid.NullUUID {
+	if id == nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: *id, Valid: true}
+}
+
+func ReadyNotificationForJSON(sqlNotification database.Notification) Notification {
+	return Notification{
+		ID:        sqlNotification.ID,
+		CreatedAt: sqlNotification.CreatedAt,
+		Type:      sqlNotification.Type,
+		ActorID:   nullUUIDPtr(sqlNotification.ActorID),
+		ChirpID:   nullUUIDPtr(sqlNotification.ChirpID),
+		ReadAt:    nullTimePtr(sqlNotification.ReadAt),
+	}
+}
+
+/*
+Record a notification for recipientID and push it to their live connections
+and subscribed browsers.
+Users are never notified about their own actions, and types the recipient
+has disabled are dropped by the insert itself.
+*/
+func (cfg *APIConfig) notify(ctx context.Context, recipientID uuid.UUID, notificationType string, actorID, chirpID uuid.NullUUID) {
+	if actorID.Valid && actorID.UUID == recipientID {
+		return
+	}
+	if actorID.Valid && cfg.isShadowBanned(ctx, actorID.UUID) {
+		return
+	}
+
+	sqlNotification, err := cfg.DBQueries.CreateNotification(ctx, database.CreateNotificationParams{
+		UserID:  recipientID,
+		ActorID: actorID,
+		Type:    notificationType,
+		ChirpID: chirpID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		fmt.Println("Error: failed to create notification -", err.Error())
+		return
+	}
+
+	notification := ReadyNotificationForJSON(sqlNotification)
+	cfg.sendPushNotification(recipientID, notification)
+
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return
+	}
+	err = cfg.Events.Publish(ctx, stream.Event{
+		Type:        "notification.created",
+		ChirpID:     chirpID.UUID,
+		AuthorID:    actorID.UUID,
+		Data:        data,
+		RecipientID: uuid.NullUUID{UUID: recipientID, Valid: true},
+	})
+	if err != nil {
+		fmt.Println("Error: failed to publish notification -", err.Error())
+	}
+}
+
+// A one-line description of a notification, used in digests and push messages.
+func notificationSummary(notificationType string, actorEmail sql.NullString) string {
+	actor := "Someone"
+	if actorEmail.Valid {
+		actor = actorEmail.String
+	}
+	switch notificationType {
+	case NotificationMention:
+		return actor + " mentioned you"
+	case NotificationReply:
+		return actor + " replied to your chirp"
+	case NotificationFollow:
+		return actor + " followed you"
+	case NotificationLike:
+		return actor + " liked your chirp"
+	case NotificationChirpyRed:
+		return "Your Chirpy Red membership is active"
+	case NotificationModerationWarning:
+		return "A moderator warned you about one of your chirps"
+	}
+	return "New activity on your account"
+}
+
+// Mentions name a user by email, e.g. "hi @alice@example.com".
+var mentionPattern = regexp.MustCompile(`@([\w.+-]+@[\w-]+(?:\.[\w-]+)+)`)
+
+// The distinct emails mentioned in a chirp body.
+func mentionedEmails(body string) []string {
+	var emails []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		email := match[1]
+		if !slices.ContainsFunc(emails, func(e string) bool { return strings.EqualFold(e, email) }) {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// Notify the author of the chirp being replied to and everyone mentioned.
+func (cfg *APIConfig) notifyChirpCreated(ctx context.Context, sqlChirp database.Chirp, parentAuthorID uuid.NullUUID) {
+	chirpID := uuid.NullUUID{UUID: sqlChirp.ID, Valid: true}
+	if parentAuthorID.Valid {
+		cfg.notify(ctx, parentAuthorID.UUID, NotificationReply, sqlChirp.UserID, chirpID)
+	}
+	for _, email := range mentionedEmails(sqlChirp.Body) {
+		sqlUser, err := cfg.DBQueries.GetUserByEmail(ctx, email)
+		if err != nil {
+			continue
+		}
+		// The reply notification already covers the parent's author.
+		if parentAuthorID.Valid && sqlUser.ID == parentAuthorID.UUID {
+			continue
+		}
+		// Blocked users cannot reach each other through mentions.
+		if cfg.isBlockedBetween(ctx, sqlUser.ID, sqlChirp.UserID.UUID) {
+			continue
+		}
+		cfg.notify(ctx, sqlUser.ID, NotificationMention, sqlChirp.UserID, chirpID)
+	}
+}
+
+func (cfg *APIConfig) GetNotificationsHandler(response http.ResponseWriter, request *http.Request) {
+	type responseParameters struct {
+		Notifications []Notification `json:"notifications"`
+		UnreadCount   int64          `json:"unread_count"`
+		NextCursor    string         `json:"next_cursor,omitempty"`
+	}
+
+	validatedID, err := cfg.authenticate(request, auth.ScopeProfileRead)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	query := request.URL.Query()
+	limit := 50
+	if urlLimit := query.Get("limit"); len(urlLimit) != 0 {
+		if n, err := strconv.Atoi(urlLimit); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+	// The cursor is the ID of the last notification on the previous page.
+	beforeID := int64(math.MaxInt64)
+	if cursor := query.Get("cursor"); len(cursor) != 0 {
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n <= 0 {
+			respondWithError(response, 400, "Invalid cursor")
+			return
+		}
+		beforeID = n
+	}
+
+	sqlNotifications, err := cfg.DBQueries.GetNotifications(request.Context(), database.GetNotificationsParams{
+		UserID:     validatedID,
+		BeforeID:   beforeID,
+		UnreadOnly: query.Get("unread") == "true",
+		MaxResults: int32(limit),
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get notifications")
+		return
+	}
+	unread, err := cfg.DBQueries.CountUnreadNotifications(request.Context(), validatedID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get notifications")
+		return
+	}
+
+	respBody := responseParameters{
+		Notifications: []Notification{},
+		UnreadCount:   unread,
+	}
+	for _, sqlNotification := range sqlNotifications {
+		respBody.Notifications = append(respBody.Notifications, ReadyNotificationForJSON(sqlNotification))
+	}
+	if len(sqlNotifications) == limit {
+		respBody.NextCursor = strconv.FormatInt(sqlNotifications[len(sqlNotifications)-1].ID, 10)
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) MarkNotificationsReadHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		IDs []int64 `json:"ids"`
+		All bool    `json:"all"`
+	}
+	type responseParameters struct {
+		Marked      int64 `json:"marked"`
+		UnreadCount int64 `json:"unread_count"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+	if !params.All && len(params.IDs) == 0 {
+		respondWithError(response, 400, "Provide notification ids or all")
+		return
+	}
+
+	var marked int64
+	var err error
+	if params.All {
+		marked, err = cfg.DBQueries.MarkAllNotificationsRead(request.Context(), validatedID)
+	} else {
+		marked, err = cfg.DBQueries.MarkNotificationsRead(request.Context(), database.MarkNotificationsReadParams{
+			UserID: validatedID,
+			Ids:    params.IDs,
+		})
+	}
+	if err != nil {
+		respondWithError(response, 500, "Server failed to update notifications")
+		return
+	}
+	unread, err := cfg.DBQueries.CountUnreadNotifications(request.Context(), validatedID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to update notifications")
+		return
+	}
+
+	data, encErr := json.Marshal(responseParameters{Marked: marked, UnreadCount: unread})
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+var digestFrequencies = []string{"off", "daily", "weekly"}
+
+type NotificationSettings struct {
+	DisabledTypes   []string `json:"disabled_types"`
+	DigestFrequency string   `json:"digest_frequency"`
+}
+
+func (cfg *APIConfig) GetNotificationSettingsHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, err := cfg.authenticate(request, auth.ScopeProfileRead)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	respBody := NotificationSettings{DisabledTypes: []string{}, DigestFrequency: "off"}
+	sqlSettings, err := cfg.DBQueries.GetNotificationSettings(request.Context(), validatedID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(response, 500, "Server failed to get notification settings")
+		return
+	}
+	if err == nil {
+		respBody.DisabledTypes = sqlSettings.DisabledTypes
+		respBody.DigestFrequency = sqlSettings.DigestFrequency
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) UpdateNotificationSettingsHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	// Start from the stored settings so that fields left out of the request keep their values.
+	params := NotificationSettings{DisabledTypes: []string{}, DigestFrequency: "off"}
+	sqlSettings, err := cfg.DBQueries.GetNotificationSettings(request.Context(), validatedID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(response, 500, "Server failed to get notification settings")
+		return
+	}
+	if err == nil {
+		params.DisabledTypes = sqlSettings.DisabledTypes
+		params.DigestFrequency = sqlSettings.DigestFrequency
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+	disabled := []string{}
+	for _, notificationType := range params.DisabledTypes {
+		if !slices.Contains(notificationTypes, notificationType) {
+			respondWithError(response, 400, fmt.Sprintf("Unknown notification type %q", notificationType))
+			return
+		}
+		if !slices.Contains(disabled, notificationType) {
+			disabled = append(disabled, notificationType)
+		}
+	}
+
+	if !slices.Contains(digestFrequencies, params.DigestFrequency) {
+		respondWithError(response, 400, "Digest frequency must be off, daily or weekly")
+		return
+	}
+
+	sqlSettings, err = cfg.DBQueries.SetNotificationSettings(request.Context(), database.SetNotificationSettingsParams{
+		UserID:          validatedID,
+		DisabledTypes:   disabled,
+		DigestFrequency: params.DigestFrequency,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to update notification settings")
+		return
+	}
+
+	data, encErr := json.Marshal(NotificationSettings{
+		DisabledTypes:   sqlSettings.DisabledTypes,
+		DigestFrequency: sqlSettings.DigestFrequency,
+	})
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
@@ -0,0 +1,604 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+const (
+	oauthCodeLifetime         = 10 * time.Minute
+	oauthAccessTokenLifetime  = 1 * time.Hour
+	oauthRefreshTokenLifetime = 60 * time.Hour * 24
+)
+
+type OAuthClient struct {
+	ID           uuid.UUID `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	Secret       string    `json:"client_secret,omitempty"`
+}
+
+func ReadyOAuthClientForJSON(sqlClient database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           sqlClient.ID,
+		CreatedAt:    sqlClient.CreatedAt,
+		Name:         sqlClient.Name,
+		RedirectURIs: sqlClient.RedirectUris,
+		Scopes:       sqlClient.Scopes,
+		Confidential: sqlClient.HashedSecret.Valid,
+	}
+}
+
+// Error body defined by RFC 6749 section 5.2.
+func respondWithOAuthError(response http.ResponseWriter, code int, errCode, description string) {
+	type oauthError struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+	data, encErr := json.Marshal(oauthError{Error: errCode, Description: description})
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	response.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(response, code, data)
+}
+
+func validRedirectURI(rawURI string) bool {
+	uri, err := url.Parse(rawURI)
+	if err != nil || uri.Fragment != "" || uri.Host == "" {
+		return false
+	}
+	switch uri.Scheme {
+	case "https":
+		return true
+	case "http":
+		return uri.Hostname() == "localhost" || uri.Hostname() == "127.0.0.1"
+	}
+	return false
+}
+
+func (cfg *APIConfig) RegisterOAuthClientHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	if len(params.Name) == 0 {
+		respondWithError(response, 400, "Client name is required")
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		respondWithError(response, 400, "At least one redirect URI is required")
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if !validRedirectURI(uri) {
+			respondWithError(response, 400, fmt.Sprintf("Invalid redirect URI %q", uri))
+			return
+		}
+	}
+	for _, scope := range params.Scopes {
+		if _, err := auth.ParseScope(scope); err != nil {
+			respondWithError(response, 400, fmt.Sprintf("Unknown scope %q", scope))
+			return
+		}
+	}
+
+	var secret string
+	var hashedSecret sql.NullString
+	if params.Confidential {
+		var err error
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(response, 500, "Server failed to generate client secret")
+			return
+		}
+		hashed, err := auth.HashPassword(secret)
+		if err != nil {
+			respondWithError(response, 500, "Server failed to generate client secret")
+			return
+		}
+		hashedSecret = sql.NullString{String: hashed, Valid: true}
+	}
+
+	sqlClient, err := cfg.DBQueries.CreateOAuthClient(request.Context(), database.CreateOAuthClientParams{
+		OwnerID:      validatedID,
+		Name:         params.Name,
+		HashedSecret: hashedSecret,
+		RedirectUris: params.RedirectURIs,
+		Scopes:       params.Scopes,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to register client")
+		return
+	}
+
+	respBody := ReadyOAuthClientForJSON(sqlClient)
+	respBody.Secret = secret
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 201, data)
+}
+
+// Public client details, used by the consent screen.
+func (cfg *APIConfig) GetOAuthClientHandler(response http.ResponseWriter, request *http.Request) {
+	clientID, err := uuid.Parse(request.PathValue("clientID"))
+	if err != nil {
+		respondWithError(response, 404, "Client not found")
+		return
+	}
+
+	sqlClient, err := cfg.DBQueries.GetOAuthClient(request.Context(), clientID)
+	if err != nil {
+		respondWithError(response, 404, "Client not found")
+		return
+	}
+
+	data, encErr := json.Marshal(ReadyOAuthClientForJSON(sqlClient))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+type authorizationRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+type authorizationError struct {
+	code        string
+	description string
+	// Errors found before the redirect URI is trusted must not redirect.
+	redirect bool
+}
+
+/*
+Check an authorization request against the registered client.
+The redirect URI must match a registered one exactly before any error is
+reported back to it, otherwise we would be an open redirector.
+*/
+func (cfg *APIConfig) validateAuthorizationRequest(request *http.Request, authReq authorizationRequest) (database.OauthClient, []auth.Scope, *authorizationError) {
+	clientID, err := uuid.Parse(authReq.ClientID)
+	if err != nil {
+		return database.OauthClient{}, nil, &authorizationError{code: "invalid_request", description: "Unknown client"}
+	}
+	sqlClient, err := cfg.DBQueries.GetOAuthClient(request.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, nil, &authorizationError{code: "invalid_request", description: "Unknown client"}
+	}
+	if !slices.Contains(sqlClient.RedirectUris, authReq.RedirectURI) {
+		return database.OauthClient{}, nil, &authorizationError{code: "invalid_request", description: "Redirect URI not registered"}
+	}
+
+	if authReq.ResponseType != "code" {
+		return sqlClient, nil, &authorizationError{code: "unsupported_response_type", description: "Only the code response type is supported", redirect: true}
+	}
+	if authReq.CodeChallenge == "" || authReq.CodeChallengeMethod != "S256" {
+		return sqlClient, nil, &authorizationError{code: "invalid_request", description: "PKCE with S256 is required", redirect: true}
+	}
+
+	scopes, err := auth.ParseScopes(authReq.Scope)
+	if err != nil || len(scopes) == 0 {
+		return sqlClient, nil, &authorizationError{code: "invalid_scope", description: "Invalid scope", redirect: true}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(sqlClient.Scopes, string(scope)) {
+			return sqlClient, nil, &authorizationError{code: "invalid_scope", description: fmt.Sprintf("Client may not request %s", scope), redirect: true}
+		}
+	}
+	return sqlClient, scopes, nil
+}
+
+func authorizationRedirect(redirectURI string, values url.Values) string {
+	uri, _ := url.Parse(redirectURI)
+	query := uri.Query()
+	for key, vals := range values {
+		for _, val := range vals {
+			query.Add(key, val)
+		}
+	}
+	uri.RawQuery = query.Encode()
+	return uri.String()
+}
+
+/*
+Entry point of the authorization code flow. Valid requests are sent on to the
+consent screen in the static site, which signs the user in and posts their
+decision to ApproveAuthorizationHandler.
+*/
+func (cfg *APIConfig) AuthorizeHandler(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	authReq := authorizationRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	_, _, authErr := cfg.validateAuthorizationRequest(request, authReq)
+	if authErr != nil {
+		if !authErr.redirect {
+			respondWithOAuthError(response, 400, authErr.code, authErr.description)
+			return
+		}
+		http.Redirect(response, request, authorizationRedirect(authReq.RedirectURI, url.Values{
+			"error":             {authErr.code},
+			"error_description": {authErr.description},
+			"state":             {authReq.State},
+		}), http.StatusFound)
+		return
+	}
+
+	http.Redirect(response, request, "/app/oauth/consent.html?"+request.URL.RawQuery, http.StatusFound)
+}
+
+func (cfg *APIConfig) ApproveAuthorizationHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		authorizationRequest
+		Approved bool `json:"approved"`
+	}
+	type responseBody struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	sqlClient, scopes, authErr := cfg.validateAuthorizationRequest(request, params.authorizationRequest)
+	var redirectTo string
+	switch {
+	case authErr != nil && !authErr.redirect:
+		respondWithError(response, 400, authErr.description)
+		return
+	case authErr != nil:
+		redirectTo = authorizationRedirect(params.RedirectURI, url.Values{
+			"error":             {authErr.code},
+			"error_description": {authErr.description},
+			"state":             {params.State},
+		})
+	case !params.Approved:
+		redirectTo = authorizationRedirect(params.RedirectURI, url.Values{
+			"error": {"access_denied"},
+			"state": {params.State},
+		})
+	default:
+		code, err := auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(response, 500, "Server failed to create authorization code")
+			return
+		}
+		err = cfg.DBQueries.CreateAuthorizationCode(request.Context(), database.CreateAuthorizationCodeParams{
+			CodeHash:      auth.HashToken(code),
+			ClientID:      sqlClient.ID,
+			UserID:        validatedID,
+			RedirectUri:   params.RedirectURI,
+			Scopes:        auth.ScopeStrings(scopes),
+			CodeChallenge: params.CodeChallenge,
+			ExpiresAt:     time.Now().Add(oauthCodeLifetime),
+		})
+		if err != nil {
+			respondWithError(response, 500, "Server failed to create authorization code")
+			return
+		}
+		redirectTo = authorizationRedirect(params.RedirectURI, url.Values{
+			"code":  {code},
+			"state": {params.State},
+		})
+	}
+
+	data, encErr := json.Marshal(responseBody{RedirectTo: redirectTo})
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+/*
+Identify the calling client from HTTP Basic credentials or the client_id and
+client_secret form fields. Confidential clients must present their secret.
+*/
+func (cfg *APIConfig) authenticateOAuthClient(request *http.Request) (database.OauthClient, error) {
+	clientIDStr, secret, ok := request.BasicAuth()
+	if !ok {
+		clientIDStr = request.PostForm.Get("client_id")
+		secret = request.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(clientIDStr)
+	if err != nil {
+		return database.OauthClient{}, errors.New("unknown client")
+	}
+	sqlClient, err := cfg.DBQueries.GetOAuthClient(request.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, errors.New("unknown client")
+	}
+	if sqlClient.HashedSecret.Valid {
+		if err := auth.CheckPasswordHash(secret, sqlClient.HashedSecret.String); err != nil {
+			return database.OauthClient{}, errors.New("client authentication failed")
+		}
+	}
+	return sqlClient, nil
+}
+
+func (cfg *APIConfig) TokenHandler(response http.ResponseWriter, request *http.Request) {
+	type tokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+
+	if err := request.ParseForm(); err != nil {
+		respondWithOAuthError(response, 400, "invalid_request", "Malformed request")
+		return
+	}
+
+	sqlClient, err := cfg.authenticateOAuthClient(request)
+	if err != nil {
+		respondWithOAuthError(response, 401, "invalid_client", err.Error())
+		return
+	}
+
+	var userID uuid.UUID
+	var scopes []auth.Scope
+	switch request.PostForm.Get("grant_type") {
+	case "authorization_code":
+		sqlCode, err := cfg.DBQueries.ConsumeAuthorizationCode(request.Context(), auth.HashToken(request.PostForm.Get("code")))
+		if err != nil {
+			respondWithOAuthError(response, 400, "invalid_grant", "Invalid or already used code")
+			return
+		}
+		if sqlCode.ClientID != sqlClient.ID || sqlCode.RedirectUri != request.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(response, 400, "invalid_grant", "Code was issued for another client or redirect URI")
+			return
+		}
+		if time.Now().After(sqlCode.ExpiresAt) {
+			respondWithOAuthError(response, 400, "invalid_grant", "Code expired")
+			return
+		}
+		if err := auth.VerifyPKCE(request.PostForm.Get("code_verifier"), sqlCode.CodeChallenge); err != nil {
+			respondWithOAuthError(response, 400, "invalid_grant", err.Error())
+			return
+		}
+		userID = sqlCode.UserID
+		scopes, err = auth.ScopesFromStrings(sqlCode.Scopes)
+		if err != nil {
+			respondWithOAuthError(response, 400, "invalid_grant", "Invalid scope")
+			return
+		}
+
+	case "refresh_token":
+		sqlRefreshToken, err := cfg.DBQueries.GetRefreshToken(request.Context(), request.PostForm.Get("refresh_token"))
+		if err != nil || !sqlRefreshToken.ClientID.Valid || sqlRefreshToken.ClientID.UUID != sqlClient.ID {
+			respondWithOAuthError(response, 400, "invalid_grant", "Token not found")
+			return
+		}
+		if sqlRefreshToken.RevokedAt.Valid || time.Now().After(sqlRefreshToken.ExpiresAt) {
+			respondWithOAuthError(response, 400, "invalid_grant", "Token expired or revoked")
+			return
+		}
+		userID = sqlRefreshToken.UserID.UUID
+		scopes, err = auth.ScopesFromStrings(sqlRefreshToken.Scopes)
+		if err != nil {
+			respondWithOAuthError(response, 400, "invalid_grant", "Invalid scope")
+			return
+		}
+
+		// A refresh may narrow the granted scopes but never widen them.
+		if requested := request.PostForm.Get("scope"); requested != "" {
+			narrowed, err := auth.ParseScopes(requested)
+			if err != nil {
+				respondWithOAuthError(response, 400, "invalid_scope", "Invalid scope")
+				return
+			}
+			for _, scope := range narrowed {
+				if !slices.Contains(scopes, scope) {
+					respondWithOAuthError(response, 400, "invalid_scope", fmt.Sprintf("Scope %s was not granted", scope))
+					return
+				}
+			}
+			scopes = narrowed
+		}
+
+		// Rotate refresh tokens so a leaked one stops working after its next use.
+		rotated, err := cfg.DBQueries.RotateRefreshToken(request.Context(), sqlRefreshToken.Token)
+		if err != nil {
+			respondWithOAuthError(response, 500, "server_error", "Server failed to rotate token")
+			return
+		}
+		if rotated != 1 {
+			respondWithOAuthError(response, 400, "invalid_grant", "Token expired or revoked")
+			return
+		}
+		cfg.audit(request.Context(), AuditTokenRefreshed, sqlRefreshToken.UserID, sqlRefreshToken.UserID,
+			"oauth client "+sqlClient.ID.String())
+
+	default:
+		respondWithOAuthError(response, 400, "unsupported_grant_type", "Only authorization_code and refresh_token are supported")
+		return
+	}
+
+	accessToken, err := auth.MakeScopedJWT(userID, sqlClient.ID.String(), scopes, cfg.Secret, oauthAccessTokenLifetime)
+	if err != nil {
+		respondWithOAuthError(response, 500, "server_error", "Server failed to authorize token")
+		return
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithOAuthError(response, 500, "server_error", "Server failed to authorize token")
+		return
+	}
+	_, err = cfg.DBQueries.CreateOAuthRefreshToken(request.Context(), database.CreateOAuthRefreshTokenParams{
+		Token:     refreshToken,
+		UserID:    uuid.NullUUID{UUID: userID, Valid: true},
+		ExpiresAt: time.Now().Add(oauthRefreshTokenLifetime),
+		ClientID:  uuid.NullUUID{UUID: sqlClient.ID, Valid: true},
+		Scopes:    auth.ScopeStrings(scopes),
+	})
+	if err != nil {
+		respondWithOAuthError(response, 500, "server_error", "Server failed to authorize token")
+		return
+	}
+
+	data, encErr := json.Marshal(tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        auth.FormatScopes(scopes),
+	})
+	if encErr != nil {
+		respondWithOAuthError(response, 500, "server_error", "Server failed to encode response")
+		return
+	}
+	response.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(response, 200, data)
+}
+
+/*
+Token introspection (RFC 7662). Clients may only introspect tokens that were
+issued to them; anything else is reported as inactive.
+*/
+func (cfg *APIConfig) IntrospectHandler(response http.ResponseWriter, request *http.Request) {
+	type introspection struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+	}
+
+	if err := request.ParseForm(); err != nil {
+		respondWithOAuthError(response, 400, "invalid_request", "Malformed request")
+		return
+	}
+
+	sqlClient, err := cfg.authenticateOAuthClient(request)
+	if err != nil {
+		respondWithOAuthError(response, 401, "invalid_client", err.Error())
+		return
+	}
+
+	token := request.PostForm.Get("token")
+	result := introspection{}
+	if accessToken, err := auth.ValidateAccessToken(token, cfg.Secret); err == nil {
+		if accessToken.ClientID == sqlClient.ID.String() {
+			result = introspection{
+				Active:    true,
+				Scope:     auth.FormatScopes(accessToken.Scopes),
+				ClientID:  accessToken.ClientID,
+				Subject:   accessToken.UserID.String(),
+				TokenType: "access_token",
+				ExpiresAt: accessToken.ExpiresAt.Unix(),
+				IssuedAt:  accessToken.IssuedAt.Unix(),
+			}
+		}
+	} else if sqlRefreshToken, err := cfg.DBQueries.GetRefreshToken(request.Context(), token); err == nil {
+		if sqlRefreshToken.ClientID.Valid && sqlRefreshToken.ClientID.UUID == sqlClient.ID &&
+			!sqlRefreshToken.RevokedAt.Valid && time.Now().Before(sqlRefreshToken.ExpiresAt) {
+			result = introspection{
+				Active:    true,
+				Scope:     strings.Join(sqlRefreshToken.Scopes, " "),
+				ClientID:  sqlClient.ID.String(),
+				Subject:   sqlRefreshToken.UserID.UUID.String(),
+				TokenType: "refresh_token",
+				ExpiresAt: sqlRefreshToken.ExpiresAt.Unix(),
+				IssuedAt:  sqlRefreshToken.CreatedAt.Unix(),
+			}
+		}
+	}
+
+	data, encErr := json.Marshal(result)
+	if encErr != nil {
+		respondWithOAuthError(response, 500, "server_error", "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+/*
+Token revocation (RFC 7009). Access tokens are short-lived JWTs and cannot be
+revoked individually, so only refresh tokens are affected. The response is
+200 whether or not the token existed.
+*/
+func (cfg *APIConfig) OAuthRevokeHandler(response http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil {
+		respondWithOAuthError(response, 400, "invalid_request", "Malformed request")
+		return
+	}
+
+	sqlClient, err := cfg.authenticateOAuthClient(request)
+	if err != nil {
+		respondWithOAuthError(response, 401, "invalid_client", err.Error())
+		return
+	}
+
+	token := request.PostForm.Get("token")
+	sqlRefreshToken, err := cfg.DBQueries.GetRefreshToken(request.Context(), token)
+	if err == nil && sqlRefreshToken.ClientID.Valid && sqlRefreshToken.ClientID.UUID == sqlClient.ID {
+		if err := cfg.DBQueries.RevokeRefreshToken(request.Context(), token); err != nil {
+			respondWithOAuthError(response, 503, "temporarily_unavailable", "Server failed to revoke token")
+			return
+		}
+		cfg.audit(request.Context(), AuditTokenRevoked, sqlRefreshToken.UserID, sqlRefreshToken.UserID,
+			"oauth refresh token, client "+sqlClient.ID.String())
+	}
+	response.WriteHeader(200)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+const (
+	oidcLoginStateLifetime = 10 * time.Minute
+	oidcStateCookie        = "chirpy_oidc_state"
+	oidcCallbackPath       = "/api/login/oidc/callback"
+)
+
+// Start a sign-in with the configured OpenID Connect issuer.
+func (cfg *APIConfig) OIDCLoginHandler(response http.ResponseWriter, request *http.Request) {
+	if cfg.OIDC == nil {
+		respondWithError(response, 404, "Single sign-on is not configured")
+		return
+	}
+
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(response, 500, "Server failed to start sign-in")
+		return
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(response, 500, "Server failed to start sign-in")
+		return
+	}
+	verifier, challenge, err := auth.MakePKCEVerifier()
+	if err != nil {
+		respondWithError(response, 500, "Server failed to start sign-in")
+		return
+	}
+
+	cfg.DBQueries.DeleteExpiredOIDCLoginStates(request.Context())
+	err = cfg.DBQueries.CreateOIDCLoginState(request.Context(), database.CreateOIDCLoginStateParams{
+		State:        state,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oidcLoginStateLifetime),
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to start sign-in")
+		return
+	}
+
+	// Ties the sign-in to this browser, so nobody can finish their own sign-in in someone else's.
+	http.SetCookie(response, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcCallbackPath,
+		MaxAge:   int(oidcLoginStateLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.Platform != "dev",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(response, request, cfg.OIDC.AuthCodeURL(state, nonce, challenge), http.StatusFound)
+}
+
+/*
+The issuer redirects back here with an authorization code, which is only
+accepted in the browser that started the sign-in. Users are matched by their
+linked identity first, then by verified email ignoring case, and are created
+if neither exists. The response is the same as a password login.
+*/
+func (cfg *APIConfig) OIDCCallbackHandler(response http.ResponseWriter, request *http.Request) {
+	if cfg.OIDC == nil {
+		respondWithError(response, 404, "Single sign-on is not configured")
+		return
+	}
+
+	query := request.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(response, 401, fmt.Sprintf("Sign-in failed - %s", errCode))
+		return
+	}
+
+	cookie, err := request.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		respondWithError(response, 401, "Sign-in was started in another browser")
+		return
+	}
+	http.SetCookie(response, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     oidcCallbackPath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cfg.Platform != "dev",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	sqlState, err := cfg.DBQueries.ConsumeOIDCLoginState(request.Context(), query.Get("state"))
+	if err != nil {
+		respondWithError(response, 401, "Unknown sign-in attempt")
+		return
+	}
+	if time.Now().After(sqlState.ExpiresAt) {
+		respondWithError(response, 401, "Sign-in attempt expired")
+		return
+	}
+
+	rawIDToken, err := cfg.OIDC.Exchange(request.Context(), query.Get("code"), sqlState.CodeVerifier)
+	if err != nil {
+		respondWithError(response, 401, fmt.Sprintf("Sign-in failed - %v", err.Error()))
+		return
+	}
+	claims, err := cfg.OIDC.VerifyIDToken(request.Context(), rawIDToken, sqlState.Nonce)
+	if err != nil {
+		cfg.audit(request.Context(), AuditLoginFailed, uuid.NullUUID{}, uuid.NullUUID{}, "single sign-on ID token rejected: "+err.Error())
+		respondWithError(response, 401, fmt.Sprintf("Sign-in failed - %v", err.Error()))
+		return
+	}
+
+	sqlUser, err := cfg.DBQueries.GetUserByIdentity(request.Context(), database.GetUserByIdentityParams{
+		Issuer:  cfg.OIDC.Issuer,
+		Subject: claims.Subject,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlUser, err = cfg.linkOIDCIdentity(request, claims)
+	}
+	if err != nil {
+		respondWithError(response, 401, fmt.Sprintf("Sign-in failed - %v", err.Error()))
+		return
+	}
+
+	cfg.respondWithLogin(response, request, sqlUser)
+}
+
+func (cfg *APIConfig) linkOIDCIdentity(request *http.Request, claims *auth.IDTokenClaims) (database.User, error) {
+	// Linking on an unverified email would let anyone claim an existing account.
+	if claims.Email == "" || !claims.EmailVerified {
+		return database.User{}, errors.New("identity provider did not supply a verified email")
+	}
+
+	email := strings.ToLower(claims.Email)
+	sqlUser, err := cfg.DBQueries.GetUserByEmailFold(request.Context(), email)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlUser, err = cfg.DBQueries.CreateUserWithoutPassword(request.Context(), email)
+	}
+	if err != nil {
+		return database.User{}, errors.New("could not find or create user")
+	}
+
+	err = cfg.DBQueries.CreateUserIdentity(request.Context(), database.CreateUserIdentityParams{
+		Issuer:  cfg.OIDC.Issuer,
+		Subject: claims.Subject,
+		UserID:  sqlUser.ID,
+	})
+	if err != nil {
+		return database.User{}, errors.New("could not link identity")
+	}
+	return sqlUser, nil
+}
@@ -0,0 +1,381 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/safehttp"
+)
+
+const (
+	webhookMaxAttempts    = 8
+	webhookBaseBackoff    = 30 * time.Second
+	webhookMaxBackoff     = 6 * time.Hour
+	webhookBatchSize      = 50
+	webhookRequestTimeout = 10 * time.Second
+)
+
+var webhookEventTypes = []string{"chirp.created", "chirp.deleted", "user.upgraded", "follow.created"}
+
+// Endpoints are user-supplied, so deliveries may only go to public addresses.
+var webhookClient = safehttp.NewClient(webhookRequestTimeout)
+
+type WebhookEndpoint struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	IsGlobal   bool      `json:"is_global"`
+	Secret     string    `json:"secret,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode int32           `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+func ReadyWebhookEndpointForJSON(sqlEndpoint database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:         sqlEndpoint.ID,
+		CreatedAt:  sqlEndpoint.CreatedAt,
+		URL:        sqlEndpoint.Url,
+		EventTypes: sqlEndpoint.EventTypes,
+		IsGlobal:   sqlEndpoint.IsGlobal,
+	}
+}
+
+func ReadyWebhookDeliveryForJSON(sqlDelivery database.WebhookDelivery) WebhookDelivery {
+	delivery := WebhookDelivery{
+		ID:             sqlDelivery.ID,
+		CreatedAt:      sqlDelivery.CreatedAt,
+		EventType:      sqlDelivery.EventType,
+		Payload:        sqlDelivery.Payload,
+		Status:         sqlDelivery.Status,
+		Attempts:       sqlDelivery.Attempts,
+		LastStatusCode: sqlDelivery.LastStatusCode.Int32,
+		LastError:      sqlDelivery.LastError.String,
+		DeliveredAt:    nullTimePtr(sqlDelivery.DeliveredAt),
+	}
+	if sqlDelivery.Status == "pending" {
+		delivery.NextAttemptAt = &sqlDelivery.NextAttemptAt
+	}
+	return delivery
+}
+
+/*
+Queue an event for every endpoint subscribed to it. Endpoints registered by
+admins as global receive every event; other endpoints only receive events
+that involve their owner, listed in userIDs.
+*/
+func (cfg *APIConfig) emitWebhookEvent(ctx context.Context, eventType string, userIDs []uuid.UUID, data any) {
+	type envelope struct {
+		ID        uuid.UUID `json:"id"`
+		Type      string    `json:"type"`
+		CreatedAt time.Time `json:"created_at"`
+		Data      any       `json:"data"`
+	}
+
+	payload, err := json.Marshal(envelope{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		fmt.Println("Error: failed to encode webhook event -", err.Error())
+		return
+	}
+
+	err = cfg.DBQueries.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventType: eventType,
+		Payload:   payload,
+		UserIds:   userIDs,
+	})
+	if err != nil {
+		fmt.Println("Error: failed to queue webhook event -", err.Error())
+	}
+}
+
+func (cfg *APIConfig) CreateWebhookEndpointHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Global     bool     `json:"global"`
+	}
+
+	token, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		respondWithError(response, 401, "Malformed request")
+		return
+	}
+	validatedID, err := auth.ValidateJWT(token, cfg.Secret)
+	if err != nil {
+		respondWithError(response, 401, fmt.Sprintf("Unauthorized - %v", err.Error()))
+		return
+	}
+	if err := cfg.checkWriteAllowed(request, validatedID); err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	endpointURL, err := url.Parse(params.URL)
+	if err != nil || endpointURL.Host == "" || (endpointURL.Scheme != "https" && cfg.Platform != "dev") {
+		respondWithError(response, 400, "Webhook URL must be an absolute https URL")
+		return
+	}
+	if err := safehttp.CheckHost(endpointURL.Hostname()); err != nil {
+		respondWithError(response, 400, "Webhook URL must point to a public address")
+		return
+	}
+	if len(params.EventTypes) == 0 {
+		respondWithError(response, 400, "At least one event type is required")
+		return
+	}
+	for _, eventType := range params.EventTypes {
+		if !slices.Contains(webhookEventTypes, eventType) {
+			respondWithError(response, 400, fmt.Sprintf("Unknown event type %q", eventType))
+			return
+		}
+	}
+	if params.Global {
+		// The token's role may be stale, so go by the one on record.
+		sqlUser, err := cfg.DBQueries.GetUser(request.Context(), validatedID)
+		if err != nil {
+			respondWithError(response, 401, "Unauthorized - user not found")
+			return
+		}
+		if !auth.Role(sqlUser.Role).AtLeast(auth.RoleAdmin) {
+			respondWithError(response, 403, "Only admins can register global webhooks")
+			return
+		}
+	}
+
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(response, 500, "Server failed to generate webhook secret")
+		return
+	}
+
+	sqlEndpoint, err := cfg.DBQueries.CreateWebhookEndpoint(request.Context(), database.CreateWebhookEndpointParams{
+		UserID:     validatedID,
+		Url:        params.URL,
+		Secret:     secret,
+		EventTypes: params.EventTypes,
+		IsGlobal:   params.Global,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to create webhook")
+		return
+	}
+
+	respBody := ReadyWebhookEndpointForJSON(sqlEndpoint)
+	respBody.Secret = secret
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 201, data)
+}
+
+func (cfg *APIConfig) GetWebhookEndpointsHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	sqlEndpoints, err := cfg.DBQueries.GetWebhookEndpointsForUser(request.Context(), validatedID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get webhooks")
+		return
+	}
+
+	respBody := []WebhookEndpoint{}
+	for _, sqlEndpoint := range sqlEndpoints {
+		respBody = append(respBody, ReadyWebhookEndpointForJSON(sqlEndpoint))
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) DeleteWebhookEndpointHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	endpointID, err := uuid.Parse(request.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(response, 404, "Webhook not found")
+		return
+	}
+
+	deleted, err := cfg.DBQueries.DeleteWebhookEndpoint(request.Context(), database.DeleteWebhookEndpointParams{
+		ID:     endpointID,
+		UserID: validatedID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to delete webhook")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(response, 404, "Webhook not found")
+		return
+	}
+	response.WriteHeader(204)
+}
+
+func (cfg *APIConfig) GetWebhookDeliveriesHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	endpointID, err := uuid.Parse(request.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(response, 404, "Webhook not found")
+		return
+	}
+	sqlEndpoint, err := cfg.DBQueries.GetWebhookEndpoint(request.Context(), endpointID)
+	if err != nil || sqlEndpoint.UserID != validatedID {
+		respondWithError(response, 404, "Webhook not found")
+		return
+	}
+
+	limit := 100
+	if urlLimit := request.URL.Query().Get("limit"); len(urlLimit) != 0 {
+		if n, err := strconv.Atoi(urlLimit); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+
+	sqlDeliveries, err := cfg.DBQueries.GetWebhookDeliveriesForEndpoint(request.Context(), database.GetWebhookDeliveriesForEndpointParams{
+		EndpointID: endpointID,
+		Limit:      int32(limit),
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get deliveries")
+		return
+	}
+
+	respBody := []WebhookDelivery{}
+	for _, sqlDelivery := range sqlDeliveries {
+		respBody = append(respBody, ReadyWebhookDeliveryForJSON(sqlDelivery))
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+/*
+Scheduled job: send due webhook deliveries and schedule retries for failures.
+Deliveries are claimed one at a time, so each claim's lease only has to
+outlast a single request rather than a whole batch of slow endpoints.
+*/
+func (cfg *APIConfig) DeliverWebhooks(ctx context.Context) {
+	for range webhookBatchSize {
+		sqlDeliveries, err := cfg.DBQueries.ClaimDueWebhookDeliveries(ctx, 1)
+		if err != nil {
+			fmt.Println("Error: failed to claim webhook deliveries -", err.Error())
+			return
+		}
+		if len(sqlDeliveries) == 0 {
+			return
+		}
+		sqlDelivery := sqlDeliveries[0]
+
+		statusCode, err := cfg.sendWebhook(ctx, sqlDelivery)
+		if err == nil {
+			cfg.DBQueries.MarkWebhookDeliverySucceeded(ctx, database.MarkWebhookDeliverySucceededParams{
+				ID:             sqlDelivery.ID,
+				LastStatusCode: sql.NullInt32{Int32: int32(statusCode), Valid: true},
+			})
+			continue
+		}
+
+		status := "pending"
+		if sqlDelivery.Attempts+1 >= webhookMaxAttempts {
+			status = "dead"
+		}
+		cfg.DBQueries.MarkWebhookDeliveryFailed(ctx, database.MarkWebhookDeliveryFailedParams{
+			ID:             sqlDelivery.ID,
+			Status:         status,
+			LastStatusCode: sql.NullInt32{Int32: int32(statusCode), Valid: statusCode != 0},
+			LastError:      sql.NullString{String: err.Error(), Valid: true},
+			NextAttemptAt:  time.Now().Add(webhookBackoff(int(sqlDelivery.Attempts))),
+		})
+	}
+}
+
+func (cfg *APIConfig) sendWebhook(ctx context.Context, sqlDelivery database.WebhookDelivery) (int, error) {
+	sqlEndpoint, err := cfg.DBQueries.GetWebhookEndpoint(ctx, sqlDelivery.EndpointID)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sqlEndpoint.Url, bytes.NewReader(sqlDelivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Chirpy-Event", sqlDelivery.EventType)
+	req.Header.Set("Chirpy-Delivery", sqlDelivery.ID.String())
+	req.Header.Set("Chirpy-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set("Chirpy-Signature", auth.SignWebhook(sqlEndpoint.Secret, now, sqlDelivery.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Exponential backoff between attempts: 30s, 1m, 2m, ... capped at 6h.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff << attempts
+	if backoff <= 0 || backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return backoff
+}
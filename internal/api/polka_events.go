@@ -0,0 +1,125 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+type PolkaEvent struct {
+	ID          string          `json:"id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int32           `json:"attempts"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+}
+
+func ReadyPolkaEventForJSON(sqlEvent database.PolkaEvent) PolkaEvent {
+	return PolkaEvent{
+		ID:          sqlEvent.ID,
+		EventType:   sqlEvent.EventType,
+		Payload:     sqlEvent.Payload,
+		Status:      sqlEvent.Status,
+		Error:       sqlEvent.Error.String,
+		Attempts:    sqlEvent.Attempts,
+		ReceivedAt:  sqlEvent.ReceivedAt,
+		ProcessedAt: nullTimePtr(sqlEvent.ProcessedAt),
+	}
+}
+
+func (cfg *APIConfig) ListPolkaEventsHandler(response http.ResponseWriter, request *http.Request) {
+	var status sql.NullString
+	if urlStatus := request.URL.Query().Get("status"); len(urlStatus) != 0 {
+		status = sql.NullString{String: urlStatus, Valid: true}
+	}
+
+	limit := 100
+	if urlLimit := request.URL.Query().Get("limit"); len(urlLimit) != 0 {
+		if n, err := strconv.Atoi(urlLimit); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+
+	sqlEvents, err := cfg.DBQueries.ListPolkaEvents(request.Context(), database.ListPolkaEventsParams{
+		Status:     status,
+		MaxResults: int32(limit),
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get events")
+		return
+	}
+
+	respBody := []PolkaEvent{}
+	for _, sqlEvent := range sqlEvents {
+		respBody = append(respBody, ReadyPolkaEventForJSON(sqlEvent))
+	}
+
+	data, encErr := json.Marshal(respBody)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+func (cfg *APIConfig) GetPolkaEventHandler(response http.ResponseWriter, request *http.Request) {
+	sqlEvent, err := cfg.DBQueries.GetPolkaEvent(request.Context(), request.PathValue("eventID"))
+	if err != nil {
+		respondWithError(response, 404, "Event not found")
+		return
+	}
+
+	data, encErr := json.Marshal(ReadyPolkaEventForJSON(sqlEvent))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+/*
+Process a stored event again. Only events that failed or never finished can be
+replayed; processed events are left alone so nothing is applied twice.
+*/
+func (cfg *APIConfig) ReplayPolkaEventHandler(response http.ResponseWriter, request *http.Request) {
+	eventID := request.PathValue("eventID")
+	sqlEvent, err := cfg.DBQueries.ClaimPolkaEventForReplay(request.Context(), eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := cfg.DBQueries.GetPolkaEvent(request.Context(), eventID); err != nil {
+			respondWithError(response, 404, "Event not found")
+			return
+		}
+		respondWithError(response, 409, "Event already processed")
+		return
+	}
+	if err != nil {
+		respondWithError(response, 500, "Server failed to claim event")
+		return
+	}
+
+	processErr := cfg.processPolkaEvent(request.Context(), sqlEvent)
+
+	sqlEvent, err = cfg.DBQueries.GetPolkaEvent(request.Context(), eventID)
+	if err != nil {
+		respondWithError(response, 500, "Server failed to get event")
+		return
+	}
+	data, encErr := json.Marshal(ReadyPolkaEventForJSON(sqlEvent))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	code := 200
+	if processErr != nil {
+		code = 422
+	}
+	respondWithJSON(response, code, data)
+}
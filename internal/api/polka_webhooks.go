@@ -1,54 +1,136 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
-	"strings"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+const (
+	polkaSignatureTolerance = 5 * time.Minute
+	maxWebhookBodyBytes     = 1 << 20
 )
 
 type parameters struct {
+	ID    string `json:"id"`
 	Event string `json:"event"`
 	Data  struct {
-		UserID string `json:"user_id"`
+		UserID      string     `json:"user_id"`
+		PeriodStart *time.Time `json:"period_start"`
+		PeriodEnd   *time.Time `json:"period_end"`
 	} `json:"data"`
 }
 
-func (cfg *APIConfig) PolkaWebhooksHandler(response http.ResponseWriter, request *http.Request) {
+/*
+Webhooks are signed with one of the configured secrets. Deployments that
+have not set up signing yet fall back to the static API key, which must match
+exactly.
+*/
+func (cfg *APIConfig) verifyPolkaRequest(request *http.Request, body []byte) bool {
+	if len(cfg.PolkaWebhookSecrets) > 0 {
+		err := auth.VerifyWebhookSignature(request.Header, body, cfg.PolkaWebhookSecrets, polkaSignatureTolerance, time.Now())
+		return err == nil
+	}
+
 	apiKey, err := auth.GetAPIKey(request.Header)
+	if err != nil || cfg.PolkaKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cfg.PolkaKey), []byte(apiKey)) == 1
+}
+
+/*
+Payloads sent with the static API key predate event IDs. A redelivery carries
+the same body, so a digest of it stands in for the ID and still lets the ledger
+drop duplicates.
+*/
+func legacyPolkaEventID(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "legacy-" + hex.EncodeToString(sum[:])
+}
+
+func (cfg *APIConfig) PolkaWebhooksHandler(response http.ResponseWriter, request *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(response, request.Body, maxWebhookBodyBytes))
 	if err != nil {
-		respondWithError(response, 401, "Malformed request")
+		respondWithError(response, 400, "Malformed request")
 		return
 	}
 
-	if !strings.Contains(cfg.PolkaKey, apiKey) {
+	if !cfg.verifyPolkaRequest(request, body) {
 		respondWithError(response, 401, "Authentication failure")
 		return
 	}
 
-	decoder := json.NewDecoder(request.Body)
 	params := parameters{}
-	if err := decoder.Decode(&params); err != nil {
+	if err := json.Unmarshal(body, &params); err != nil {
 		respondWithError(response, 400, "Malformed request")
 		return
 	}
+	if params.ID == "" {
+		if len(cfg.PolkaWebhookSecrets) > 0 {
+			respondWithError(response, 400, "Missing event ID")
+			return
+		}
+		params.ID = legacyPolkaEventID(body)
+	}
 
-	if !strings.Contains(params.Event, "user.upgraded") {
-		response.WriteHeader(204)
+	_, err = cfg.DBQueries.RecordPolkaEvent(request.Context(), database.RecordPolkaEventParams{
+		ID:        params.ID,
+		EventType: params.Event,
+		Payload:   body,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to record event")
 		return
 	}
 
-	userID, err := uuid.Parse(params.Data.UserID)
+	// Only one delivery of an event can claim it. Retries of an event that was
+	// already processed, or is being processed right now, are acknowledged
+	// without doing the work again.
+	sqlEvent, err := cfg.DBQueries.ClaimPolkaEvent(request.Context(), params.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		response.WriteHeader(204)
+		return
+	}
 	if err != nil {
-		respondWithError(response, 400, "Invalid user ID")
+		respondWithError(response, 500, "Server failed to record event")
 		return
 	}
 
-	if err := cfg.DBQueries.ActivateChirpyRed(request.Context(), userID); err != nil {
-		respondWithError(response, 404, "Invalid user ID")
+	if err := cfg.processPolkaEvent(request.Context(), sqlEvent); err != nil {
+		if errors.Is(err, errUnknownPolkaUser) {
+			respondWithError(response, 404, err.Error())
+			return
+		}
+		respondWithError(response, 500, "Server failed to process event")
 		return
 	}
 	response.WriteHeader(204)
 }
+
+// Apply a claimed event from its stored payload and record the outcome in the ledger.
+func (cfg *APIConfig) processPolkaEvent(ctx context.Context, sqlEvent database.PolkaEvent) error {
+	params := parameters{}
+	err := json.Unmarshal(sqlEvent.Payload, &params)
+	if err == nil {
+		err = cfg.applyPolkaEvent(ctx, params)
+	}
+	if err != nil {
+		cfg.DBQueries.MarkPolkaEventFailed(ctx, database.MarkPolkaEventFailedParams{
+			ID:    sqlEvent.ID,
+			Error: sql.NullString{String: err.Error(), Valid: true},
+		})
+		return err
+	}
+	return cfg.DBQueries.MarkPolkaEventProcessed(ctx, sqlEvent.ID)
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/push"
+	"github.com/notsoexpert/gowebserver/internal/safehttp"
+)
+
+const (
+	pushTTL         = 24 * time.Hour
+	pushSendTimeout = 30 * time.Second
+)
+
+type PushSubscription struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Endpoint  string    `json:"endpoint"`
+}
+
+func ReadyPushSubscriptionForJSON(sqlSub database.PushSubscription) PushSubscription {
+	return PushSubscription{
+		ID:        sqlSub.ID,
+		CreatedAt: sqlSub.CreatedAt,
+		Endpoint:  sqlSub.Endpoint,
+	}
+}
+
+// The key browsers need as applicationServerKey when calling pushManager.subscribe.
+func (cfg *APIConfig) GetVAPIDPublicKeyHandler(response http.ResponseWriter, request *http.Request) {
+	type responseParameters struct {
+		PublicKey string `json:"public_key"`
+	}
+
+	if cfg.Push == nil {
+		respondWithError(response, 404, "Push notifications are not enabled")
+		return
+	}
+
+	data, encErr := json.Marshal(responseParameters{PublicKey: cfg.Push.Keys.PublicKeyString()})
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
+// Accepts the JSON form of a browser PushSubscription.
+func (cfg *APIConfig) CreatePushSubscriptionHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+	if cfg.Push == nil {
+		respondWithError(response, 404, "Push notifications are not enabled")
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	endpointURL, err := url.Parse(params.Endpoint)
+	if err != nil || endpointURL.Host == "" || (endpointURL.Scheme != "https" && cfg.Platform != "dev") {
+		respondWithError(response, 400, "Push endpoint must be an absolute https URL")
+		return
+	}
+	if err := safehttp.CheckHost(endpointURL.Hostname()); err != nil {
+		respondWithError(response, 400, "Push endpoint must point to a public address")
+		return
+	}
+	sub := push.Subscription{Endpoint: params.Endpoint, P256dh: params.Keys.P256dh, Auth: params.Keys.Auth}
+	if err := sub.Validate(); err != nil {
+		respondWithError(response, 400, fmt.Sprintf("Invalid subscription keys - %v", err.Error()))
+		return
+	}
+
+	sqlSub, err := cfg.DBQueries.UpsertPushSubscription(request.Context(), database.UpsertPushSubscriptionParams{
+		UserID:   validatedID,
+		Endpoint: sub.Endpoint,
+		P256dh:   sub.P256dh,
+		Auth:     sub.Auth,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(response, 409, "Push endpoint is registered to another user")
+		return
+	}
+	if err != nil {
+		respondWithError(response, 500, "Server failed to save push subscription")
+		return
+	}
+
+	data, encErr := json.Marshal(ReadyPushSubscriptionForJSON(sqlSub))
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 201, data)
+}
+
+func (cfg *APIConfig) DeletePushSubscriptionHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, ok := cfg.validateUserJWT(response, request)
+	if !ok {
+		return
+	}
+
+	subscriptionID, err := uuid.Parse(request.PathValue("subscriptionID"))
+	if err != nil {
+		respondWithError(response, 404, "Push subscription not found")
+		return
+	}
+
+	deleted, err := cfg.DBQueries.DeletePushSubscription(request.Context(), database.DeletePushSubscriptionParams{
+		ID:     subscriptionID,
+		UserID: validatedID,
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to delete push subscription")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(response, 404, "Push subscription not found")
+		return
+	}
+	response.WriteHeader(204)
+}
+
+// What the service worker receives; it shows title and body and opens url on click.
+type pushPayload struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+	Data  any    `json:"data"`
+}
+
+/*
+Push a message to every browser the recipient has subscribed. Runs in the
+background so a slow push service never holds up the request that caused
+it; build is only called once there is somewhere to send to. Subscriptions
+the push service reports as gone are removed.
+*/
+func (cfg *APIConfig) sendPush(recipientID uuid.UUID, build func(ctx context.Context) pushPayload) {
+	if cfg.Push == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), pushSendTimeout)
+		defer cancel()
+
+		sqlSubs, err := cfg.DBQueries.GetPushSubscriptionsForUser(ctx, recipientID)
+		if err != nil {
+			fmt.Println("Error: failed to get push subscriptions -", err.Error())
+			return
+		}
+		if len(sqlSubs) == 0 {
+			return
+		}
+
+		payload, err := json.Marshal(build(ctx))
+		if err != nil {
+			return
+		}
+		for _, sqlSub := range sqlSubs {
+			err := cfg.Push.Send(ctx, push.Subscription{
+				Endpoint: sqlSub.Endpoint,
+				P256dh:   sqlSub.P256dh,
+				Auth:     sqlSub.Auth,
+			}, payload, pushTTL)
+			if errors.Is(err, push.ErrSubscriptionGone) {
+				cfg.DBQueries.DeletePushSubscriptionByEndpoint(ctx, sqlSub.Endpoint)
+				continue
+			}
+			if err != nil {
+				fmt.Println("Error: failed to send push notification -", err.Error())
+			}
+		}
+	}()
+}
+
+// The email of a user, for naming them in push messages.
+func (cfg *APIConfig) userEmail(ctx context.Context, userID *uuid.UUID) sql.NullString {
+	if userID == nil {
+		return sql.NullString{}
+	}
+	sqlUser, err := cfg.DBQueries.GetUser(ctx, *userID)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: sqlUser.Email, Valid: true}
+}
+
+func (cfg *APIConfig) sendPushNotification(recipientID uuid.UUID, notification Notification) {
+	cfg.sendPush(recipientID, func(ctx context.Context) pushPayload {
+		return pushPayload{
+			Title: "Chirpy",
+			Body:  notificationSummary(notification.Type, cfg.userEmail(ctx, notification.ActorID)),
+			URL:   "/app/",
+			Data:  notification,
+		}
+	})
+}
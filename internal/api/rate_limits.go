@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/ratelimit"
+)
+
+// Quotas for one route. Anonymous callers get the free quota, counted per IP.
+type RouteLimit struct {
+	Name      string
+	Free      ratelimit.Quota
+	ChirpyRed ratelimit.Quota
+}
+
+var (
+	PostChirpLimit = RouteLimit{
+		Name:      "chirps",
+		Free:      ratelimit.Quota{Limit: 10, Window: time.Minute},
+		ChirpyRed: ratelimit.Quota{Limit: 30, Window: time.Minute},
+	}
+	CreateUserLimit = RouteLimit{
+		Name:      "signup",
+		Free:      ratelimit.Quota{Limit: 5, Window: time.Hour},
+		ChirpyRed: ratelimit.Quota{Limit: 5, Window: time.Hour},
+	}
+	LoginLimit = RouteLimit{
+		Name:      "login",
+		Free:      ratelimit.Quota{Limit: 10, Window: time.Minute},
+		ChirpyRed: ratelimit.Quota{Limit: 10, Window: time.Minute},
+	}
+	MagicLinkLimit = RouteLimit{
+		Name:      "magic-link",
+		Free:      ratelimit.Quota{Limit: 5, Window: time.Hour},
+		ChirpyRed: ratelimit.Quota{Limit: 5, Window: time.Hour},
+	}
+)
+
+/*
+Work out who sent a request, for rate limits and idempotency keys: the user
+behind a bearer token or a live API key, or failing both the client IP. All
+of a user's keys share one bucket, so minting more keys does not raise their
+limit. Invalid credentials are counted against the IP and the handler
+rejects them as usual.
+*/
+func (cfg *APIConfig) callerKey(request *http.Request) (string, uuid.NullUUID) {
+	if token, err := auth.GetBearerToken(request.Header); err == nil {
+		if accessToken, err := auth.ValidateAccessToken(token, cfg.Secret); err == nil {
+			return "user:" + accessToken.UserID.String(), userIDOf(accessToken.UserID)
+		}
+	}
+	if key, err := auth.GetAPIKey(request.Header); err == nil {
+		sqlKey, err := cfg.DBQueries.GetAPIKeyByHash(request.Context(), auth.HashAPIKey(key))
+		live := err == nil && !sqlKey.RevokedAt.Valid &&
+			(!sqlKey.ExpiresAt.Valid || time.Now().Before(sqlKey.ExpiresAt.Time))
+		if live {
+			return "user:" + sqlKey.UserID.String(), userIDOf(sqlKey.UserID)
+		}
+	}
+	return "ip:" + cfg.clientIP(request), uuid.NullUUID{}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+/*
+Wrap a route in a token bucket limit. Every response carries the RateLimit
+headers; refused requests get 429 with Retry-After. If the store fails the
+request goes through, since an outage there should not take the API down.
+*/
+func (cfg *APIConfig) RateLimit(limit RouteLimit, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.RateLimits == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, userID := cfg.callerKey(r)
+		quota := limit.Free
+		if userID.Valid {
+			if isChirpyRed, err := cfg.DBQueries.IsChirpyRed(r.Context(), userID.UUID); err == nil && isChirpyRed {
+				quota = limit.ChirpyRed
+			}
+		}
+
+		result, err := cfg.RateLimits.Take(r.Context(), limit.Name+":"+key, quota)
+		if err != nil {
+			fmt.Println("Error: rate limit check failed -", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Policy", quota.Policy())
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+			respondWithError(w, 429, "Too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"unicode"
 
 	"github.com/google/uuid"
 )
@@ -14,15 +15,113 @@ type responseParameters struct {
 	Error  string    `json:"error,omitempty"`
 }
 
+// A word in a chirp body, with its byte offsets so it can be replaced in place.
+type bodyWord struct {
+	text       string
+	start, end int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+/*
+Split a chirp into the words that filters match against. Words are runs of
+letters and digits, so punctuation, '#' and line breaks all separate them.
+*/
+func tokenizeBody(body string) []bodyWord {
+	var words []bodyWord
+	start := -1
+	for i, r := range body {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			words = append(words, bodyWord{text: body[start:i], start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, bodyWord{text: body[start:], start: start, end: len(body)})
+	}
+	return words
+}
+
+// The lowercased words of a filter phrase, split the same way as chirp bodies.
+func phraseWords(phrase string) []string {
+	return strings.FieldsFunc(strings.ToLower(phrase), func(r rune) bool { return !isWordRune(r) })
+}
+
+// Matches words and phrases in chirp bodies, ignoring case and punctuation.
+type wordMatcher struct {
+	phrases [][]string
+}
+
+func newWordMatcher(phrases []string) wordMatcher {
+	matcher := wordMatcher{}
+	for _, phrase := range phrases {
+		if words := phraseWords(phrase); len(words) > 0 {
+			matcher.phrases = append(matcher.phrases, words)
+		}
+	}
+	return matcher
+}
+
+// The length in words of a phrase starting at words[i], or 0 if none does.
+func (matcher wordMatcher) matchAt(words []bodyWord, i int) int {
+	for _, phrase := range matcher.phrases {
+		if i+len(phrase) > len(words) {
+			continue
+		}
+		matched := true
+		for j, word := range phrase {
+			if strings.ToLower(words[i+j].text) != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return len(phrase)
+		}
+	}
+	return 0
+}
+
+func (matcher wordMatcher) matches(body string) bool {
+	if len(matcher.phrases) == 0 {
+		return false
+	}
+	words := tokenizeBody(body)
+	for i := range words {
+		if matcher.matchAt(words, i) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+var profaneWords = newWordMatcher([]string{"kerfuffle", "sharbert", "fornax"})
+
+// Replace each profane word with asterisks, leaving the text around it alone.
 func cleanResponseBody(body string) string {
-	words := strings.Split(body, " ")
-	for i, word := range words {
-		switch strings.ToLower(word) {
-		case "kerfuffle", "sharbert", "fornax":
-			words[i] = "****"
+	words := tokenizeBody(body)
+	var cleaned strings.Builder
+	copied := 0
+	for i := 0; i < len(words); {
+		n := profaneWords.matchAt(words, i)
+		if n == 0 {
+			i++
+			continue
+		}
+		for _, word := range words[i : i+n] {
+			cleaned.WriteString(body[copied:word.start])
+			cleaned.WriteString("****")
+			copied = word.end
 		}
+		i += n
 	}
-	return strings.Join(words, " ")
+	cleaned.WriteString(body[copied:])
+	return cleaned.String()
 }
 
 func respondWithError(response http.ResponseWriter, code int, msg string) {
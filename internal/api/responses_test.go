@@ -0,0 +1,65 @@
+package api
+
+import "testing"
+
+func TestCleanResponseBody(t *testing.T) {
+	cases := []struct {
+		body     string
+		expected string
+	}{
+		{"This is a kerfuffle opinion", "This is a **** opinion"},
+		{"Sharbert! and FORNAX?", "****! and ****?"},
+		{"fornax\nkerfuffle", "****\n****"},
+		{"a sharbertine day", "a sharbertine day"},
+		{"", ""},
+		{"nothing to see here", "nothing to see here"},
+	}
+	for _, c := range cases {
+		if cleaned := cleanResponseBody(c.body); cleaned != c.expected {
+			t.Errorf(`cleanResponseBody(%q) = %q, expected %q`, c.body, cleaned, c.expected)
+		}
+	}
+}
+
+func TestWordMatcher(t *testing.T) {
+	matcher := newWordMatcher([]string{"spoiler", "Season Finale", "#bake-off"})
+	cases := []struct {
+		body     string
+		expected bool
+	}{
+		{"no spoiler here", true},
+		{"SPOILER!", true},
+		{"big spoiler\nahead", true},
+		{"(spoiler)", true},
+		{"spoilers everywhere", false},
+		{"the season finale was great", true},
+		{"the season, finale", true},
+		{"the season was final", false},
+		{"watching #bake off tonight", true},
+		{"bake-off", true},
+		{"nothing muted", false},
+	}
+	for _, c := range cases {
+		if matched := matcher.matches(c.body); matched != c.expected {
+			t.Errorf(`matches(%q) = %v, expected %v`, c.body, matched, c.expected)
+		}
+	}
+}
+
+func TestEmptyWordMatcher(t *testing.T) {
+	matcher := newWordMatcher([]string{"", "  ", "!!"})
+	if matcher.matches("anything at all !!") {
+		t.Errorf(`matcher with no usable phrases matched`)
+	}
+}
+
+func TestCleanResponseBodyPhrase(t *testing.T) {
+	saved := profaneWords
+	defer func() { profaneWords = saved }()
+	profaneWords = newWordMatcher([]string{"bad word"})
+
+	cleaned := cleanResponseBody("a Bad, word and a bad thing")
+	if cleaned != "a ****, **** and a bad thing" {
+		t.Errorf(`cleanResponseBody with a phrase = %q`, cleaned)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/auth"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+/*
+Only let users holding at least the given role through. The role in the token
+is a quick first check; the stored role decides, so a demotion takes effect
+immediately rather than when the access token expires.
+*/
+func (cfg *APIConfig) RequireRole(role auth.Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, 401, "Malformed request")
+			return
+		}
+
+		userID, tokenRole, err := auth.ValidateRoleJWT(token, cfg.Secret)
+		if err != nil {
+			respondWithError(w, 401, fmt.Sprintf("Unauthorized - %v", err.Error()))
+			return
+		}
+
+		if !tokenRole.AtLeast(role) {
+			respondWithError(w, 403, "Action not permitted")
+			return
+		}
+		sqlUser, err := cfg.DBQueries.GetUser(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, 401, "Unauthorized - user not found")
+			return
+		}
+		if !auth.Role(sqlUser.Role).AtLeast(role) {
+			respondWithError(w, 403, "Action not permitted")
+			return
+		}
+		if err := cfg.checkWriteAllowed(r, userID); err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (cfg *APIConfig) SetUserRoleHandler(response http.ResponseWriter, request *http.Request) {
+	type requestParameters struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := uuid.Parse(request.PathValue("userID"))
+	if err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := requestParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(response, 400, "Malformed request")
+		return
+	}
+
+	role, err := auth.ParseRole(params.Role)
+	if err != nil {
+		respondWithError(response, 400, "Invalid role")
+		return
+	}
+
+	if _, err := cfg.DBQueries.GetUser(request.Context(), userID); err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+
+	err = cfg.DBQueries.SetUserRole(request.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: string(role),
+	})
+	if err != nil {
+		respondWithError(response, 500, "Server failed to update role")
+		return
+	}
+	cfg.audit(request.Context(), AuditRoleChanged, cfg.requestUserID(request), userIDOf(userID), "role "+string(role))
+	response.WriteHeader(204)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/spam"
+)
+
+// How far back the spam checks look at an author's chirps.
+const (
+	spamHistoryWindow = 24 * time.Hour
+	spamHistoryLimit  = 50
+)
+
+/*
+Run a chirp that is about to be posted through the configured spam checks.
+The checks are a safety net rather than a gate, so a failed lookup lets the
+chirp through.
+*/
+func (cfg *APIConfig) checkSpam(ctx context.Context, userID uuid.UUID, body string) spam.Result {
+	if len(cfg.SpamChecks) == 0 {
+		return spam.Result{Verdict: spam.Allow}
+	}
+
+	sqlUser, err := cfg.DBQueries.GetUser(ctx, userID)
+	if err != nil {
+		fmt.Println("Error: spam checks skipped -", err.Error())
+		return spam.Result{Verdict: spam.Allow}
+	}
+	now := time.Now()
+	sqlRecent, err := cfg.DBQueries.GetRecentChirpsByUser(ctx, database.GetRecentChirpsByUserParams{
+		UserID:    uuid.NullUUID{UUID: userID, Valid: true},
+		CreatedAt: now.Add(-spamHistoryWindow),
+		Limit:     spamHistoryLimit,
+	})
+	if err != nil {
+		fmt.Println("Error: spam checks skipped -", err.Error())
+		return spam.Result{Verdict: spam.Allow}
+	}
+
+	candidate := spam.Candidate{
+		Body:             body,
+		AccountCreatedAt: sqlUser.CreatedAt,
+		Now:              now,
+	}
+	for _, recent := range sqlRecent {
+		candidate.Recent = append(candidate.Recent, spam.RecentChirp{Body: recent.Body, CreatedAt: recent.CreatedAt})
+	}
+	return cfg.SpamChecks.Evaluate(candidate)
+}
+
+// Put a held chirp in the moderation queue. Dismissing the case publishes the chirp.
+func (cfg *APIConfig) flagChirp(ctx context.Context, sqlChirp database.Chirp, result spam.Result) {
+	_, err := cfg.DBQueries.OpenFlaggedModerationCase(ctx, database.OpenFlaggedModerationCaseParams{
+		ChirpID:       uuid.NullUUID{UUID: sqlChirp.ID, Valid: true},
+		SubjectID:     sqlChirp.UserID,
+		ChirpBody:     sqlChirp.Body,
+		FlaggedReason: fmt.Sprintf("%s: %s", result.Check, result.Reason),
+	})
+	if err != nil {
+		fmt.Println("Error: failed to queue held chirp -", err.Error())
+	}
+}
+
+// Release a chirp the spam filter held and announce it as if it had just been posted.
+func (cfg *APIConfig) releaseHeldChirp(ctx context.Context, chirpID uuid.UUID) error {
+	if err := cfg.DBQueries.UnhideChirp(ctx, chirpID); err != nil {
+		return err
+	}
+	sqlChirp, err := cfg.DBQueries.GetChirp(ctx, chirpID)
+	if err != nil {
+		return err
+	}
+	cfg.announceChirp(ctx, sqlChirp)
+	return nil
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/stream"
+)
+
+const (
+	streamHeartbeatInterval = 15 * time.Second
+	streamRetention         = 24 * time.Hour
+	streamBacklogPageSize   = 500
+	streamBufferSize        = 64
+)
+
+func streamEventFromSQL(sqlEvent database.StreamEvent) stream.Event {
+	return stream.Event{
+		ID:        sqlEvent.ID,
+		Type:      sqlEvent.EventType,
+		ChirpID:   sqlEvent.ChirpID,
+		AuthorID:  sqlEvent.UserID,
+		Hashtags:  sqlEvent.Hashtags,
+		Data:      sqlEvent.Payload,
+		ReplyToID: sqlEvent.ReplyToID,
+	}
+}
+
+/*
+Record a chirp event and push it to live stream subscribers on every
+instance through the event bus. Events are stored first so that a client
+reconnecting with Last-Event-ID can catch up on anything it missed.
+*/
+func (cfg *APIConfig) publishChirpEvent(ctx context.Context, eventType string, chirp Chirp, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error: failed to encode stream event -", err.Error())
+		return
+	}
+
+	sqlEvent, err := cfg.DBQueries.CreateStreamEvent(ctx, database.CreateStreamEventParams{
+		EventType: eventType,
+		ChirpID:   chirp.ID,
+		UserID:    chirp.UserID,
+		Hashtags:  stream.Hashtags(chirp.Body),
+		Payload:   payload,
+		ReplyToID: nullUUIDOf(chirp.ReplyToID),
+	})
+	if err != nil {
+		fmt.Println("Error: failed to record stream event -", err.Error())
+		return
+	}
+	if err := cfg.Events.Publish(ctx, streamEventFromSQL(sqlEvent)); err != nil {
+		fmt.Println("Error: failed to publish stream event -", err.Error())
+	}
+}
+
+func writeStreamEvent(response http.ResponseWriter, event stream.Event) error {
+	_, err := fmt.Fprintf(response, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return err
+}
+
+func (cfg *APIConfig) StreamHandler(response http.ResponseWriter, request *http.Request) {
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		respondWithError(response, 500, "Streaming unsupported")
+		return
+	}
+
+	query := request.URL.Query()
+	filter := stream.Filter{
+		Hashtag: strings.TrimPrefix(query.Get("hashtag"), "#"),
+	}
+	if urlAuthorID := query.Get("author_id"); len(urlAuthorID) != 0 {
+		authorID, err := uuid.Parse(urlAuthorID)
+		if err != nil {
+			respondWithError(response, 400, "Invalid author ID")
+			return
+		}
+		filter.AuthorID = uuid.NullUUID{UUID: authorID, Valid: true}
+	}
+	viewerID, err := cfg.viewer(request)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+	if query.Get("following") == "true" {
+		if !viewerID.Valid {
+			respondWithError(response, 401, "Unauthorized - following requires credentials")
+			return
+		}
+		followees, err := cfg.DBQueries.GetFolloweeIDs(request.Context(), viewerID.UUID)
+		if err != nil {
+			respondWithError(response, 500, "Server failed to get follow list")
+			return
+		}
+		filter.Following = true
+		filter.Followees = followees
+	}
+	// Block and mute lists are read once; changes apply from the next connection.
+	if viewerID.Valid {
+		hidden, err := cfg.DBQueries.GetHiddenAuthorIDs(request.Context(), viewerID.UUID)
+		if err != nil {
+			respondWithError(response, 500, "Server failed to get block list")
+			return
+		}
+		filter.Hidden = hidden
+	}
+
+	preference := cfg.sensitivePreference(request.Context(), viewerID)
+	muted := cfg.mutedWords(request.Context(), viewerID)
+
+	lastEventID := request.Header.Get("Last-Event-ID")
+	if len(lastEventID) == 0 {
+		lastEventID = query.Get("last_event_id")
+	}
+	var lastSent int64
+	if len(lastEventID) != 0 {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			respondWithError(response, 400, "Invalid Last-Event-ID")
+			return
+		}
+		lastSent = id
+	}
+
+	// Subscribe before reading the backlog so nothing published in between is lost.
+	sub := cfg.Stream.Subscribe(streamBufferSize)
+	defer sub.Close()
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.Header().Set("Connection", "keep-alive")
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(200)
+	flusher.Flush()
+
+	for resume := lastSent > 0; resume; {
+		sqlEvents, err := cfg.DBQueries.GetStreamEventsAfter(request.Context(), database.GetStreamEventsAfterParams{
+			ID:    lastSent,
+			Limit: streamBacklogPageSize,
+		})
+		if err != nil {
+			return
+		}
+		for _, sqlEvent := range sqlEvents {
+			event := streamEventFromSQL(sqlEvent)
+			lastSent = event.ID
+			if !filter.Match(event) || muted.mutesEvent(viewerID, event) {
+				continue
+			}
+			event = collapseSensitiveEvent(event, viewerID, preference)
+			if err := writeStreamEvent(response, event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		resume = len(sqlEvents) == streamBacklogPageSize
+	}
+
+	// Live events are only checked against what the backlog already sent. IDs
+	// can reach the bus out of order, since a lower ID may commit after a
+	// higher one, so the last live ID is no guide to what is still to come.
+	backlogEnd := lastSent
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(response, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and resumes.
+				return
+			}
+			if event.ID <= backlogEnd || !filter.Match(event) || muted.mutesEvent(viewerID, event) {
+				continue
+			}
+			event = collapseSensitiveEvent(event, viewerID, preference)
+			if err := writeStreamEvent(response, event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// Scheduled job: forget stream events too old to be worth resuming from.
+func (cfg *APIConfig) PruneStreamEvents(ctx context.Context) {
+	if err := cfg.DBQueries.DeleteStreamEventsBefore(ctx, time.Now().Add(-streamRetention)); err != nil {
+		fmt.Println("Error: failed to prune stream events -", err.Error())
+	}
+}
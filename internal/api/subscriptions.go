@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notsoexpert/gowebserver/internal/database"
+)
+
+var errUnknownPolkaUser = errors.New("Invalid user ID")
+
+// Used when Polka does not say when a billing period ends.
+const defaultSubscriptionPeriod = 30 * 24 * time.Hour
+
+/*
+When a subscription lapses, access is kept until the period ends. Users
+grandfathered in from before subscriptions were tracked have a period that
+never ends, so theirs is cut to one default period from the lapse.
+*/
+func lapsedPeriodEnd(periodEnd, now time.Time) time.Time {
+	if latest := now.Add(defaultSubscriptionPeriod); periodEnd.After(latest) {
+		return latest
+	}
+	return periodEnd
+}
+
+// Apply a lapse event, capping the period end as lapsedPeriodEnd describes.
+func (cfg *APIConfig) lapseSubscription(ctx context.Context, userID uuid.UUID, canceled bool) error {
+	sqlSubscription, err := cfg.DBQueries.GetSubscription(ctx, userID)
+	if err != nil {
+		return err
+	}
+	periodEnd := lapsedPeriodEnd(sqlSubscription.PeriodEnd, time.Now())
+	if canceled {
+		_, err = cfg.DBQueries.CancelSubscription(ctx, database.CancelSubscriptionParams{
+			UserID:    userID,
+			PeriodEnd: periodEnd,
+		})
+		return err
+	}
+	_, err = cfg.DBQueries.MarkSubscriptionPastDue(ctx, database.MarkSubscriptionPastDueParams{
+		UserID:    userID,
+		PeriodEnd: periodEnd,
+	})
+	return err
+}
+
+/*
+Move a user's Chirpy Red subscription through its lifecycle:
+
+	user.upgraded, user.renewed  start a new paid period
+	user.payment_failed          past due, access kept until the period ends
+	user.canceled                canceled, access kept until the period ends
+	user.downgraded              access ends immediately
+
+Other events are ignored.
+*/
+func (cfg *APIConfig) applyPolkaEvent(ctx context.Context, params parameters) error {
+	var apply func(uuid.UUID) error
+	switch params.Event {
+	case "user.upgraded", "user.renewed":
+		apply = func(userID uuid.UUID) error {
+			periodStart := time.Now()
+			if params.Data.PeriodStart != nil {
+				periodStart = *params.Data.PeriodStart
+			}
+			periodEnd := periodStart.Add(defaultSubscriptionPeriod)
+			if params.Data.PeriodEnd != nil {
+				periodEnd = *params.Data.PeriodEnd
+			}
+			_, err := cfg.DBQueries.StartSubscriptionPeriod(ctx, database.StartSubscriptionPeriodParams{
+				UserID:      userID,
+				PeriodStart: periodStart,
+				PeriodEnd:   periodEnd,
+			})
+			return err
+		}
+	case "user.payment_failed":
+		apply = func(userID uuid.UUID) error {
+			return cfg.lapseSubscription(ctx, userID, false)
+		}
+	case "user.canceled":
+		apply = func(userID uuid.UUID) error {
+			return cfg.lapseSubscription(ctx, userID, true)
+		}
+	case "user.downgraded":
+		apply = func(userID uuid.UUID) error {
+			_, err := cfg.DBQueries.EndSubscription(ctx, userID)
+			return err
+		}
+	default:
+		return nil
+	}
+
+	userID, err := uuid.Parse(params.Data.UserID)
+	if err != nil {
+		return errUnknownPolkaUser
+	}
+	if _, err := cfg.DBQueries.GetUser(ctx, userID); err != nil {
+		return errUnknownPolkaUser
+	}
+	// A lapse event for a user without a subscription has nothing to change.
+	if err := apply(userID); errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	cfg.audit(ctx, AuditChirpyRedPrefix+strings.TrimPrefix(params.Event, "user."), uuid.NullUUID{}, userIDOf(userID), "polka "+params.Event)
+	if params.Event == "user.upgraded" {
+		cfg.emitWebhookEvent(ctx, "user.upgraded", []uuid.UUID{userID}, map[string]uuid.UUID{
+			"user_id": userID,
+		})
+		cfg.notify(ctx, userID, NotificationChirpyRed, uuid.NullUUID{}, uuid.NullUUID{})
+	}
+	return nil
+}
+
+// Scheduled job: end memberships whose paid period has run out.
+func (cfg *APIConfig) ExpireLapsedSubscriptions(ctx context.Context) {
+	expired, err := cfg.DBQueries.ExpireLapsedSubscriptions(ctx)
+	if err != nil {
+		fmt.Println("Error: failed to expire subscriptions -", err.Error())
+		return
+	}
+	if expired > 0 {
+		fmt.Printf("Expired %d Chirpy Red subscriptions\n", expired)
+	}
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLapsedPeriodEnd(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	grandfathered := time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name      string
+		periodEnd time.Time
+		expected  time.Time
+	}{
+		{"grandfathered", grandfathered, now.Add(defaultSubscriptionPeriod)},
+		{"paid period", now.Add(5 * 24 * time.Hour), now.Add(5 * 24 * time.Hour)},
+		{"already ended", now.Add(-time.Hour), now.Add(-time.Hour)},
+	}
+	for _, c := range cases {
+		if periodEnd := lapsedPeriodEnd(c.periodEnd, now); !periodEnd.Equal(c.expected) {
+			t.Errorf(`%s: lapsedPeriodEnd = %v, expected %v`, c.name, periodEnd, c.expected)
+		}
+	}
+}
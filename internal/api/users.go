@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -19,6 +20,7 @@ type User struct {
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Role         string    `json:"role"`
 }
 
 type credentials struct {
@@ -26,6 +28,19 @@ type credentials struct {
 	Password string `json:"password"`
 }
 
+// Chirpy Red is derived from the user's subscription rather than stored on the user.
+func (cfg *APIConfig) readyUserForJSON(ctx context.Context, sqlUser database.User) User {
+	isChirpyRed, _ := cfg.DBQueries.IsChirpyRed(ctx, sqlUser.ID)
+	return User{
+		ID:          sqlUser.ID,
+		CreatedAt:   sqlUser.CreatedAt,
+		UpdatedAt:   sqlUser.UpdatedAt,
+		Email:       sqlUser.Email,
+		IsChirpyRed: isChirpyRed,
+		Role:        sqlUser.Role,
+	}
+}
+
 func (cfg *APIConfig) CreateUserHandler(response http.ResponseWriter, request *http.Request) {
 	decoder := json.NewDecoder(request.Body)
 	params := credentials{}
@@ -49,13 +64,7 @@ func (cfg *APIConfig) CreateUserHandler(response http.ResponseWriter, request *h
 		return
 	}
 
-	var user User = User{
-		ID:          sqlUser.ID,
-		CreatedAt:   sqlUser.CreatedAt,
-		UpdatedAt:   sqlUser.UpdatedAt,
-		Email:       sqlUser.Email,
-		IsChirpyRed: sqlUser.IsChirpyRed,
-	}
+	user := cfg.readyUserForJSON(request.Context(), sqlUser)
 	data, encErr := json.Marshal(user)
 	if encErr != nil {
 		respondWithError(response, 500, "Server failed to encode response")
@@ -64,6 +73,28 @@ func (cfg *APIConfig) CreateUserHandler(response http.ResponseWriter, request *h
 	respondWithJSON(response, 201, data)
 }
 
+func (cfg *APIConfig) GetCurrentUserHandler(response http.ResponseWriter, request *http.Request) {
+	validatedID, err := cfg.authenticate(request, auth.ScopeProfileRead)
+	if err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
+
+	sqlUser, err := cfg.DBQueries.GetUser(request.Context(), validatedID)
+	if err != nil {
+		respondWithError(response, 404, "User not found")
+		return
+	}
+
+	user := cfg.readyUserForJSON(request.Context(), sqlUser)
+	data, encErr := json.Marshal(user)
+	if encErr != nil {
+		respondWithError(response, 500, "Server failed to encode response")
+		return
+	}
+	respondWithJSON(response, 200, data)
+}
+
 func (cfg *APIConfig) UpdateCredentialsHandler(response http.ResponseWriter, request *http.Request) {
 	accessToken, err := auth.GetBearerToken(request.Header)
 	if err != nil {
@@ -76,6 +107,10 @@ func (cfg *APIConfig) UpdateCredentialsHandler(response http.ResponseWriter, req
 		respondWithError(response, 401, fmt.Sprintf("Unauthorized - %v", err.Error()))
 		return
 	}
+	if err := cfg.checkWriteAllowed(request, validatedID); err != nil {
+		respondWithAuthError(response, err)
+		return
+	}
 
 	decoder := json.NewDecoder(request.Body)
 	params := credentials{}
@@ -108,19 +143,15 @@ func (cfg *APIConfig) UpdateCredentialsHandler(response http.ResponseWriter, req
 		return
 	}
 
+	cfg.audit(request.Context(), AuditCredentialsUpdated, userIDOf(validatedID), userIDOf(validatedID), "email and password")
+
 	sqlUser, err := cfg.DBQueries.GetUser(request.Context(), validatedID)
 	if err != nil {
 		respondWithError(response, 400, "Server failed to retrieve user")
 		return
 	}
 
-	var user User = User{
-		ID:          sqlUser.ID,
-		CreatedAt:   sqlUser.CreatedAt,
-		UpdatedAt:   sqlUser.UpdatedAt,
-		Email:       sqlUser.Email,
-		IsChirpyRed: sqlUser.IsChirpyRed,
-	}
+	user := cfg.readyUserForJSON(request.Context(), sqlUser)
 	data, encErr := json.Marshal(user)
 	if encErr != nil {
 		respondWithError(response, 500, "Server failed to encode response")
@@ -140,16 +171,32 @@ func (cfg *APIConfig) LoginHandler(response http.ResponseWriter, request *http.R
 
 	sqlUser, err := cfg.DBQueries.GetUserByEmail(request.Context(), params.Email)
 	if err != nil {
+		cfg.audit(request.Context(), AuditLoginFailed, uuid.NullUUID{}, uuid.NullUUID{}, fmt.Sprintf("unknown email %q", params.Email))
 		respondWithError(response, 401, "Incorrect email or password")
 		return
 	}
 
 	if err := auth.CheckPasswordHash(params.Password, sqlUser.HashedPassword); err != nil {
+		cfg.audit(request.Context(), AuditLoginFailed, uuid.NullUUID{}, userIDOf(sqlUser.ID), "incorrect password")
 		respondWithError(response, 401, "Incorrect email or password")
 		return
 	}
 
-	token, err := auth.MakeJWT(sqlUser.ID, cfg.Secret, 1*time.Hour)
+	cfg.respondWithLogin(response, request, sqlUser)
+}
+
+/*
+Issue a fresh access and refresh token pair for a user who has just proven
+their identity, whether by password or another sign-in method.
+*/
+func (cfg *APIConfig) respondWithLogin(response http.ResponseWriter, request *http.Request, sqlUser database.User) {
+	if until, suspended := suspendedUntil(sqlUser.AccountState, sqlUser.SuspendedUntil); suspended {
+		cfg.audit(request.Context(), AuditLoginFailed, uuid.NullUUID{}, userIDOf(sqlUser.ID), "account suspended")
+		respondWithAuthError(response, accountSuspendedError{until: until})
+		return
+	}
+
+	token, err := auth.MakeRoleJWT(sqlUser.ID, auth.Role(sqlUser.Role), cfg.Secret, 1*time.Hour)
 	if err != nil {
 		respondWithError(response, 500, "Server failed to authorize token")
 		return
@@ -170,15 +217,11 @@ func (cfg *APIConfig) LoginHandler(response http.ResponseWriter, request *http.R
 		return
 	}
 
-	var user User = User{
-		ID:           sqlUser.ID,
-		CreatedAt:    sqlUser.CreatedAt,
-		UpdatedAt:    sqlUser.UpdatedAt,
-		Email:        sqlUser.Email,
-		Token:        token,
-		RefreshToken: sqlRefreshToken.Token,
-		IsChirpyRed:  sqlUser.IsChirpyRed,
-	}
+	cfg.audit(request.Context(), AuditLoginSucceeded, userIDOf(sqlUser.ID), userIDOf(sqlUser.ID), "via "+request.URL.Path)
+
+	user := cfg.readyUserForJSON(request.Context(), sqlUser)
+	user.Token = token
+	user.RefreshToken = sqlRefreshToken.Token
 	data, encErr := json.Marshal(user)
 	if encErr != nil {
 		respondWithError(response, 500, "Server failed to encode response")
@@ -205,13 +248,29 @@ func (cfg *APIConfig) RefreshHandler(response http.ResponseWriter, request *http
 	}
 	if sqlRefreshToken.RevokedAt.Valid {
 		respondWithError(response, 401, "Token revoked")
+		return
+	}
+	if sqlRefreshToken.ClientID.Valid {
+		respondWithError(response, 401, "Token was issued to a third-party client")
+		return
 	}
 
-	newAccessToken, err := auth.MakeJWT(sqlRefreshToken.UserID.UUID, cfg.Secret, 1*time.Hour)
+	sqlUser, err := cfg.DBQueries.GetUser(request.Context(), sqlRefreshToken.UserID.UUID)
+	if err != nil {
+		respondWithError(response, 401, "Token not found")
+		return
+	}
+	if until, suspended := suspendedUntil(sqlUser.AccountState, sqlUser.SuspendedUntil); suspended {
+		respondWithAuthError(response, accountSuspendedError{until: until})
+		return
+	}
+
+	newAccessToken, err := auth.MakeRoleJWT(sqlUser.ID, auth.Role(sqlUser.Role), cfg.Secret, 1*time.Hour)
 	if err != nil {
 		respondWithError(response, 500, "Server failed to authorize token")
 		return
 	}
+	cfg.audit(request.Context(), AuditTokenRefreshed, userIDOf(sqlUser.ID), userIDOf(sqlUser.ID), "")
 
 	type AccessTokenResponse struct {
 		Token string `json:"token"`
@@ -234,7 +293,7 @@ func (cfg *APIConfig) RevokeHandler(response http.ResponseWriter, request *http.
 		return
 	}
 
-	_, err = cfg.DBQueries.GetRefreshToken(request.Context(), refreshToken)
+	sqlRefreshToken, err := cfg.DBQueries.GetRefreshToken(request.Context(), refreshToken)
 	if err != nil {
 		respondWithError(response, 401, "Token not found")
 		return
@@ -245,5 +304,6 @@ func (cfg *APIConfig) RevokeHandler(response http.ResponseWriter, request *http.
 		respondWithError(response, 500, "Server failed to revoke token")
 		return
 	}
+	cfg.audit(request.Context(), AuditTokenRevoked, sqlRefreshToken.UserID, sqlRefreshToken.UserID, "refresh token")
 	response.WriteHeader(204)
 }
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+type Scope string
+
+const (
+	ScopeChirpsRead  Scope = "chirps:read"
+	ScopeChirpsWrite Scope = "chirps:write"
+	ScopeProfileRead Scope = "profile:read"
+)
+
+const apiKeyPrefix = "chirpy_"
+
+func ParseScope(s string) (Scope, error) {
+	switch Scope(s) {
+	case ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileRead:
+		return Scope(s), nil
+	}
+	return "", errors.New("unknown scope")
+}
+
+/*
+Generate a new personal API key. Only the hash of the key is stored,
+so the plaintext must be shown to the user once and then discarded.
+*/
+func MakeAPIKey() (string, error) {
+	randData := make([]byte, 32)
+	_, err := rand.Read(randData)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(randData), nil
+}
+
+func HashAPIKey(key string) string {
+	return HashToken(key)
+}
+
+// The visible start of a key, stored so users can tell their keys apart.
+func APIKeyPrefix(key string) string {
+	return key[:min(len(key), len(apiKeyPrefix)+8)]
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -141,3 +142,135 @@ func TestGetBearerToken(t *testing.T) {
 		t.Errorf(`GetBearerToken returned incorrect token string - %q, %v`, tokenString, err)
 	}
 }
+
+func TestRoleJWT(t *testing.T) {
+	id := uuid.New()
+	tokenSecret := "secret"
+
+	tokenString, err := MakeRoleJWT(id, RoleAdmin, tokenSecret, time.Hour)
+	if err != nil {
+		t.Errorf(`MakeRoleJWT(%q, %q) failed = %v`, id.String(), RoleAdmin, err)
+	}
+
+	retID, role, err := ValidateRoleJWT(tokenString, tokenSecret)
+	if err != nil {
+		t.Errorf(`ValidateRoleJWT(%q, %q) failed = %v`, tokenString, tokenSecret, err)
+	}
+	if retID != id || role != RoleAdmin {
+		t.Errorf(`ValidateRoleJWT returned %v, %q - expected %v, %q`, retID, role, id, RoleAdmin)
+	}
+}
+
+func TestRoleAtLeast(t *testing.T) {
+	if !RoleAdmin.AtLeast(RoleModerator) {
+		t.Errorf(`admin should satisfy moderator`)
+	}
+	if RoleUser.AtLeast(RoleModerator) {
+		t.Errorf(`user should not satisfy moderator`)
+	}
+	if _, err := ParseRole("superuser"); err == nil {
+		t.Errorf(`ParseRole("superuser") succeeded`)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	key, err := MakeAPIKey()
+	if err != nil {
+		t.Errorf(`MakeAPIKey failed = %v`, err)
+	}
+	if !strings.HasPrefix(APIKeyPrefix(key), "chirpy_") {
+		t.Errorf(`APIKeyPrefix(%q) = %q - missing chirpy_ prefix`, key, APIKeyPrefix(key))
+	}
+	if HashAPIKey(key) != HashAPIKey(key) {
+		t.Errorf(`HashAPIKey(%q) is not deterministic`, key)
+	}
+	if HashAPIKey(key) == key {
+		t.Errorf(`HashAPIKey(%q) returned the plaintext key`, key)
+	}
+}
+
+func TestGetAPIKey(t *testing.T) {
+	header := make(http.Header)
+	header.Set("Authorization", "ApiKey chirpy_abc")
+
+	key, err := GetAPIKey(header)
+	if err != nil || key != "chirpy_abc" {
+		t.Errorf(`GetAPIKey failed - %q, %v`, key, err)
+	}
+}
+
+func TestScopedJWT(t *testing.T) {
+	id := uuid.New()
+	tokenSecret := "secret"
+	scopes := []Scope{ScopeChirpsRead}
+
+	tokenString, err := MakeScopedJWT(id, "client", scopes, tokenSecret, time.Hour)
+	if err != nil {
+		t.Errorf(`MakeScopedJWT(%q) failed = %v`, id.String(), err)
+	}
+
+	token, err := ValidateAccessToken(tokenString, tokenSecret)
+	if err != nil {
+		t.Errorf(`ValidateAccessToken(%q, %q) failed = %v`, tokenString, tokenSecret, err)
+	}
+	if !token.Allows(ScopeChirpsRead) || token.Allows(ScopeChirpsWrite) {
+		t.Errorf(`ValidateAccessToken returned wrong scopes %v`, token.Scopes)
+	}
+
+	if _, err := ValidateJWT(tokenString, tokenSecret); err == nil {
+		t.Errorf(`ValidateJWT(%q, %q) succeeded - third-party token accepted as first-party`, tokenString, tokenSecret)
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636 appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if err := VerifyPKCE(verifier, challenge); err != nil {
+		t.Errorf(`VerifyPKCE(%q, %q) failed = %v`, verifier, challenge, err)
+	}
+	if err := VerifyPKCE(verifier+"x", challenge); err == nil {
+		t.Errorf(`VerifyPKCE succeeded with wrong verifier`)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+	now := time.Now()
+	header := make(http.Header)
+	header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	header.Set(WebhookSignatureHeader, SignWebhook("new-secret", now, body))
+
+	if err := VerifyWebhookSignature(header, body, []string{"old-secret", "new-secret"}, 5*time.Minute, now); err != nil {
+		t.Errorf(`VerifyWebhookSignature failed with rotated secret = %v`, err)
+	}
+	if err := VerifyWebhookSignature(header, []byte(`{"id":"evt_2"}`), []string{"new-secret"}, 5*time.Minute, now); err == nil {
+		t.Errorf(`VerifyWebhookSignature succeeded with tampered body`)
+	}
+	if err := VerifyWebhookSignature(header, body, []string{"new-secret"}, 5*time.Minute, now.Add(10*time.Minute)); err == nil {
+		t.Errorf(`VerifyWebhookSignature succeeded outside tolerance`)
+	}
+	if err := VerifyWebhookSignature(header, body, []string{""}, 5*time.Minute, now); err == nil {
+		t.Errorf(`VerifyWebhookSignature succeeded with empty secret`)
+	}
+}
+
+func TestUnsubscribeToken(t *testing.T) {
+	userID := uuid.New()
+	token := MakeUnsubscribeToken(userID, "secret")
+
+	gotID, err := ValidateUnsubscribeToken(token, "secret")
+	if err != nil || gotID != userID {
+		t.Errorf(`ValidateUnsubscribeToken = %v, %v, expected %v`, gotID, err, userID)
+	}
+	if _, err := ValidateUnsubscribeToken(token, "other secret"); err == nil {
+		t.Errorf(`ValidateUnsubscribeToken succeeded with the wrong secret`)
+	}
+	forged := MakeUnsubscribeToken(uuid.New(), "secret")
+	_, signature, _ := strings.Cut(forged, ".")
+	prefix, _, _ := strings.Cut(token, ".")
+	if _, err := ValidateUnsubscribeToken(prefix+"."+signature, "secret"); err == nil {
+		t.Errorf(`ValidateUnsubscribeToken accepted another user's signature`)
+	}
+}
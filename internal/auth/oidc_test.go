@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// An in-process identity provider that issues ID tokens for a fixed user.
+type fakeIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	nonce    string
+	audience string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf(`rsa.GenerateKey failed = %v`, err)
+	}
+	idp := &fakeIdP{key: key, clientID: "chirpy"}
+	idp.audience = idp.clientID
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kid": "test-key",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, IDTokenClaims{
+			Email:         "sso@example.com",
+			EmailVerified: true,
+			Nonce:         idp.nonce,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    idp.server.URL,
+				Subject:   "user-123",
+				Audience:  jwt.ClaimStrings{idp.audience},
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		})
+		token.Header["kid"] = "test-key"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.nonce = "nonce-1"
+	ctx := context.Background()
+
+	provider, err := DiscoverOIDCProvider(ctx, idp.server.Client(), idp.server.URL, idp.clientID, "secret", "http://localhost/callback")
+	if err != nil {
+		t.Fatalf(`DiscoverOIDCProvider failed = %v`, err)
+	}
+
+	verifier, _, err := MakePKCEVerifier()
+	if err != nil {
+		t.Fatalf(`MakePKCEVerifier failed = %v`, err)
+	}
+	rawIDToken, err := provider.Exchange(ctx, "good-code", verifier)
+	if err != nil {
+		t.Fatalf(`Exchange failed = %v`, err)
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, rawIDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf(`VerifyIDToken failed = %v`, err)
+	}
+	if claims.Subject != "user-123" || claims.Email != "sso@example.com" || !claims.EmailVerified {
+		t.Errorf(`VerifyIDToken returned unexpected claims %+v`, claims)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, rawIDToken, "other-nonce"); err == nil {
+		t.Errorf(`VerifyIDToken succeeded with wrong nonce`)
+	}
+	if _, err := provider.Exchange(ctx, "bad-code", verifier); err == nil {
+		t.Errorf(`Exchange succeeded with bad code`)
+	}
+}
+
+func TestOIDCWrongAudience(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.audience = "someone-else"
+	ctx := context.Background()
+
+	provider, err := DiscoverOIDCProvider(ctx, idp.server.Client(), idp.server.URL, idp.clientID, "secret", "http://localhost/callback")
+	if err != nil {
+		t.Fatalf(`DiscoverOIDCProvider failed = %v`, err)
+	}
+	rawIDToken, err := provider.Exchange(ctx, "good-code", "verifier")
+	if err != nil {
+		t.Fatalf(`Exchange failed = %v`, err)
+	}
+	if _, err := provider.VerifyIDToken(ctx, rawIDToken, ""); err == nil {
+		t.Errorf(`VerifyIDToken succeeded for a token issued to another audience`)
+	}
+}
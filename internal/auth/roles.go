@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"errors"
+)
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+func ParseRole(s string) (Role, error) {
+	switch Role(s) {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return Role(s), nil
+	}
+	return "", errors.New("unknown role")
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleAdmin:
+		return 2
+	case RoleModerator:
+		return 1
+	}
+	return 0
+}
+
+/*
+Roles are hierarchical: an admin can do anything a moderator can,
+and a moderator can do anything a user can.
+*/
+func (r Role) AtLeast(required Role) bool {
+	return r.rank() >= required.rank()
+}
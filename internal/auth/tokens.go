@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"net/http"
@@ -12,39 +13,82 @@ import (
 	"github.com/google/uuid"
 )
 
+type Claims struct {
+	Role     Role   `json:"role,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+	return MakeRoleJWT(userID, RoleUser, tokenSecret, expiresIn)
+}
+
+func MakeRoleJWT(userID uuid.UUID, role Role, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return signJWT(userID, Claims{Role: role}, tokenSecret, expiresIn)
+}
+
+func signJWT(userID uuid.UUID, claims Claims, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
 		Issuer:    "chirpy",
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
-	})
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(tokenSecret))
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (any, error) {
+	id, _, err := ValidateRoleJWT(tokenString, tokenSecret)
+	return id, err
+}
+
+/*
+Validate the token like ValidateJWT and also return the role it was issued with.
+Tokens issued before roles existed carry no role claim and are treated as RoleUser.
+Tokens issued to third-party OAuth clients are rejected here; they are only
+accepted by endpoints that check scopes through ValidateAccessToken.
+*/
+func ValidateRoleJWT(tokenString, tokenSecret string) (uuid.UUID, Role, error) {
+	id, claims, err := parseJWT(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.UUID{0}, "", err
+	}
+	if claims.ClientID != "" {
+		return uuid.UUID{0}, "", errors.New("token was issued to a third-party client")
+	}
+	role := claims.Role
+	if role == "" {
+		role = RoleUser
+	}
+	return id, role, nil
+}
+
+func parseJWT(tokenString, tokenSecret string) (uuid.UUID, *Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
 		return []byte(tokenSecret), nil
 	})
 	if err != nil {
-		return uuid.UUID{0}, err
+		return uuid.UUID{0}, nil, err
 	}
-	issuer, err := token.Claims.(*jwt.RegisteredClaims).GetIssuer()
+	issuer, err := claims.GetIssuer()
 	if err != nil {
-		return uuid.UUID{0}, err
+		return uuid.UUID{0}, nil, err
 	}
 	if issuer != "chirpy" {
-		return uuid.UUID{0}, errors.New("invalid token")
+		return uuid.UUID{0}, nil, errors.New("invalid token")
 	}
-	subject, err := token.Claims.(*jwt.RegisteredClaims).GetSubject()
+	subject, err := claims.GetSubject()
 	if err != nil {
-		return uuid.UUID{0}, err
+		return uuid.UUID{0}, nil, err
 	}
 	id, err := uuid.Parse(subject)
 	if err != nil {
-		return uuid.UUID{0}, err
+		return uuid.UUID{0}, nil, err
 	}
-	return id, nil
+	return id, claims, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -67,3 +111,12 @@ func MakeRefreshToken() (string, error) {
 	}
 	return hex.EncodeToString(randData), nil
 }
+
+/*
+Random tokens such as API keys, authorization codes and sign-in links are
+long enough that a fast unsalted hash is safe, and it lets us look them up.
+*/
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+/*
+Unsubscribe links must work with a single click from an email, without
+signing in, so the token is the user ID plus an HMAC over it. It does not
+expire; the worst it can do is turn off that user's emails.
+*/
+func MakeUnsubscribeToken(userID uuid.UUID, secret string) string {
+	return base64.RawURLEncoding.EncodeToString(userID[:]) + "." + unsubscribeSignature(userID, secret)
+}
+
+func ValidateUnsubscribeToken(token, secret string) (uuid.UUID, error) {
+	encodedID, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.UUID{}, errors.New("malformed unsubscribe token")
+	}
+	rawID, err := base64.RawURLEncoding.DecodeString(encodedID)
+	if err != nil {
+		return uuid.UUID{}, errors.New("malformed unsubscribe token")
+	}
+	userID, err := uuid.FromBytes(rawID)
+	if err != nil {
+		return uuid.UUID{}, errors.New("malformed unsubscribe token")
+	}
+	if !hmac.Equal([]byte(signature), []byte(unsubscribeSignature(userID, secret))) {
+		return uuid.UUID{}, errors.New("invalid unsubscribe token")
+	}
+	return userID, nil
+}
+
+func unsubscribeSignature(userID uuid.UUID, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("unsubscribe:"))
+	mac.Write(userID[:])
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WebhookTimestampHeader = "Polka-Timestamp"
+	WebhookSignatureHeader = "Polka-Signature"
+)
+
+/*
+Sign a webhook body the way Polka does: HMAC-SHA256 over "<timestamp>.<body>",
+hex encoded and sent as "v1=<signature>".
+*/
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+Check the signature headers on a webhook request against the raw body.
+Several secrets may be active while one is being rotated out, and the
+signature header may carry several comma-separated signatures for the same
+reason. Requests whose timestamp is outside the tolerance are rejected so
+that a captured request cannot be replayed later.
+*/
+func VerifyWebhookSignature(headers http.Header, body []byte, secrets []string, tolerance time.Duration, now time.Time) error {
+	timestampStr := headers.Get(WebhookTimestampHeader)
+	signatureStr := headers.Get(WebhookSignatureHeader)
+	if timestampStr == "" || signatureStr == "" {
+		return errors.New("signature headers missing")
+	}
+
+	unix, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return errors.New("timestamp header malformed")
+	}
+	timestamp := time.Unix(unix, 0)
+	if timestamp.Before(now.Add(-tolerance)) || timestamp.After(now.Add(tolerance)) {
+		return errors.New("timestamp outside tolerance")
+	}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		expected := []byte(SignWebhook(secret, timestamp, body))
+		for _, signature := range strings.Split(signatureStr, ",") {
+			if hmac.Equal(expected, []byte(strings.TrimSpace(signature))) {
+				return nil
+			}
+		}
+	}
+	return errors.New("signature mismatch")
+}
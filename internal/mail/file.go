@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Writes each message to an .eml file in Dir. Useful for local development and tests.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	data, err := formatMessage(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	randData := make([]byte, 4)
+	if _, err := rand.Read(randData); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000"), hex.EncodeToString(randData))
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+}
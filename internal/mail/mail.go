@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// Extra headers such as List-Unsubscribe.
+	Headers map[string]string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+/*
+Notes messages on stdout instead of sending them. Used when no SMTP server is
+configured. Bodies are left out because they carry working sign-in and
+unsubscribe links, and logs are often readable by more people than the inbox.
+*/
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	fmt.Printf("Mail to %s: %s (body withheld, %d bytes)\n", msg.To, msg.Subject, len(msg.Text))
+	return nil
+}
+
+func (msg Message) validate() error {
+	if msg.To == "" {
+		return errors.New("message has no recipient")
+	}
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errors.New("message headers contain line breaks")
+	}
+	for name, value := range msg.Headers {
+		if strings.ContainsAny(name+value, "\r\n") || strings.Contains(name, ":") {
+			return errors.New("message headers contain line breaks")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHeaderInjection(t *testing.T) {
+	msg := Message{
+		To:      "user@example.com\r\nBcc: victim@example.com",
+		Subject: "Hello",
+		Text:    "Hi",
+	}
+	if err := (LogMailer{}).Send(context.Background(), msg); err == nil {
+		t.Errorf(`Send succeeded with a line break in the recipient`)
+	}
+}
+
+func TestFormatMultipart(t *testing.T) {
+	data, err := formatMessage("chirpy@example.com", Message{
+		To:      "user@example.com",
+		Subject: "Hello",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+	})
+	if err != nil {
+		t.Fatalf(`format failed = %v`, err)
+	}
+	body := string(data)
+	for _, want := range []string{"multipart/alternative", "plain body", "<p>html body</p>", "To: user@example.com"} {
+		if !strings.Contains(body, want) {
+			t.Errorf(`formatted message is missing %q`, want)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := FileMailer{Dir: dir, From: "chirpy@example.com"}
+	err := mailer.Send(context.Background(), Message{
+		To:      "user@example.com",
+		Subject: "Digest",
+		Text:    "plain body",
+		Headers: map[string]string{"List-Unsubscribe": "<https://chirpy.example/unsubscribe>"},
+	})
+	if err != nil {
+		t.Fatalf(`Send failed = %v`, err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf(`expected one message file, found %d (%v)`, len(files), err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatalf(`ReadFile failed = %v`, err)
+	}
+	for _, want := range []string{"To: user@example.com", "List-Unsubscribe: <https://chirpy.example/unsubscribe>", "plain body"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf(`message file is missing %q`, want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Package push sends Web Push notifications: payloads are encrypted for the
+// browser with RFC 8291 (aes128gcm) and the sender identifies itself to the
+// push service with a VAPID JWT per RFC 8292.
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/notsoexpert/gowebserver/internal/safehttp"
+)
+
+const (
+	// Every payload is sent as a single record of this size.
+	recordSize = 4096
+	// Delimiter, GCM tag and header overhead leave this much room for the payload.
+	MaxPayloadSize = recordSize - 1 - 16 - 86
+	vapidLifetime  = 12 * time.Hour
+)
+
+// The push service no longer accepts messages for this subscription; it should be deleted.
+var ErrSubscriptionGone = errors.New("push subscription is gone")
+
+// A browser's PushSubscription, with keys base64url encoded as the Push API returns them.
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// The application server's VAPID key pair and contact URI (mailto: or https:).
+type VAPIDKeys struct {
+	Private *ecdsa.PrivateKey
+	Subject string
+}
+
+func GenerateVAPIDKeys(subject string) (*VAPIDKeys, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKeys{Private: key, Subject: subject}, nil
+}
+
+// Load a private key stored as a base64url-encoded 32-byte scalar.
+func ParseVAPIDKeys(privateKey, subject string) (*VAPIDKeys, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("vapid private key: %w", err)
+	}
+	ecdhKey, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("vapid private key: %w", err)
+	}
+	pub := ecdhKey.PublicKey().Bytes()
+	return &VAPIDKeys{
+		Private: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(pub[1:33]),
+				Y:     new(big.Int).SetBytes(pub[33:]),
+			},
+			D: new(big.Int).SetBytes(raw),
+		},
+		Subject: subject,
+	}, nil
+}
+
+func (k *VAPIDKeys) PrivateKeyString() string {
+	return base64.RawURLEncoding.EncodeToString(k.Private.D.FillBytes(make([]byte, 32)))
+}
+
+// The uncompressed public key, base64url encoded. Browsers pass it to pushManager.subscribe as applicationServerKey.
+func (k *VAPIDKeys) PublicKeyString() string {
+	pub, _ := k.Private.PublicKey.ECDH()
+	return base64.RawURLEncoding.EncodeToString(pub.Bytes())
+}
+
+// The Authorization header value for a push to endpoint.
+func (k *VAPIDKeys) authorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.RegisteredClaims{
+		Audience:  jwt.ClaimStrings{u.Scheme + "://" + u.Host},
+		ExpiresAt: jwt.NewNumericDate(now.Add(vapidLifetime)),
+		Subject:   k.Subject,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(k.Private)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + token + ", k=" + k.PublicKeyString(), nil
+}
+
+/*
+Encrypt a payload for a subscription with a fresh ephemeral key and salt,
+producing the aes128gcm body described in RFC 8291 section 4.
+*/
+func Encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encrypt(sub, payload, asPrivate, salt)
+}
+
+func encrypt(sub Subscription, payload []byte, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("push payload is %d bytes, larger than %d", len(payload), MaxPayloadSize)
+	}
+	rawUAPublic, err := base64.RawURLEncoding.DecodeString(sub.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("subscription p256dh: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(rawUAPublic)
+	if err != nil {
+		return nil, fmt.Errorf("subscription p256dh: %w", err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(sub.Auth)
+	if err != nil || len(authSecret) != 16 {
+		return nil, errors.New("subscription auth secret must be 16 bytes")
+	}
+
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), rawUAPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdf.Key(sha256.New, ecdhSecret, authSecret, string(keyInfo), 32)
+	if err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt, record size, key ID length and the ephemeral public key as key ID.
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(recordSize))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+
+	// A single, final record: the payload followed by the 0x02 delimiter.
+	record := append(append([]byte{}, payload...), 0x02)
+	body.Write(gcm.Seal(nil, nonce, record, nil))
+	return body.Bytes(), nil
+}
+
+type Sender struct {
+	Keys   *VAPIDKeys
+	Client *http.Client
+}
+
+// Endpoints come from browsers via users, so the client only connects to public addresses.
+func NewSender(keys *VAPIDKeys) *Sender {
+	return &Sender{Keys: keys, Client: safehttp.NewClient(10 * time.Second)}
+}
+
+// Deliver an encrypted payload. ttl is how long the push service may hold it for an offline browser.
+func (s *Sender) Send(ctx context.Context, sub Subscription, payload []byte, ttl time.Duration) error {
+	body, err := Encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+	authorization, err := s.Keys.authorization(sub.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("push service returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Check that the keys are usable before storing a subscription.
+func (sub Subscription) Validate() error {
+	rawUAPublic, err := base64.RawURLEncoding.DecodeString(sub.P256dh)
+	if err != nil {
+		return errors.New("p256dh is not base64url")
+	}
+	if _, err := ecdh.P256().NewPublicKey(rawUAPublic); err != nil {
+		return errors.New("p256dh is not a P-256 public key")
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(sub.Auth)
+	if err != nil || len(authSecret) != 16 {
+		return errors.New("auth must be a 16-byte base64url secret")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package push
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func b64(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf(`decode %q: %v`, s, err)
+	}
+	return data
+}
+
+// The worked example from RFC 8291 appendix A.
+func TestEncryptRFC8291Example(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(b64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatalf(`NewPrivateKey failed = %v`, err)
+	}
+	sub := Subscription{
+		P256dh: "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+		Auth:   "BTBZMqHH6r4Tts7J_aSIgg",
+	}
+	body, err := encrypt(sub, []byte("When I grow up, I want to be a watermelon"), asPrivate, b64(t, "DGv6ra1nlYgDCS1FRnbzlw"))
+	if err != nil {
+		t.Fatalf(`encrypt failed = %v`, err)
+	}
+
+	expected := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if got := base64.RawURLEncoding.EncodeToString(body); got != expected {
+		t.Errorf(`encrypt = %s, expected %s`, got, expected)
+	}
+}
+
+// What a browser does with a push message: derive the same keys from its own private key and decrypt.
+func decrypt(body []byte, uaPrivate *ecdh.PrivateKey, authSecret []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("body too short")
+	}
+	salt := body[:16]
+	idLen := int(body[20])
+	asPublicRaw := body[21 : 21+idLen]
+	ciphertext := body[21+idLen:]
+	if binary.BigEndian.Uint32(body[16:20]) < uint32(len(ciphertext)) {
+		return nil, errors.New("record larger than record size")
+	}
+
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicRaw)
+	if err != nil {
+		return nil, err
+	}
+	ecdhSecret, err := uaPrivate.ECDH(asPublic)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo := append([]byte("WebPush: info\x00"), uaPrivate.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, asPublicRaw...)
+	ikm, _ := hkdf.Key(sha256.New, ecdhSecret, authSecret, string(keyInfo), 32)
+	prk, _ := hkdf.Extract(sha256.New, ikm, salt)
+	cek, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	record, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(record) == 0 || record[len(record)-1] != 0x02 {
+		return nil, errors.New("missing final record delimiter")
+	}
+	return record[:len(record)-1], nil
+}
+
+func TestSendToFakePushService(t *testing.T) {
+	uaPrivate, _ := ecdh.P256().GenerateKey(rand.Reader)
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	keys, err := GenerateVAPIDKeys("mailto:ops@chirpy.example")
+	if err != nil {
+		t.Fatalf(`GenerateVAPIDKeys failed = %v`, err)
+	}
+
+	var received []byte
+	var ttl string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+
+		// Check the VAPID header the way a push service would.
+		authorization := strings.TrimPrefix(r.Header.Get("Authorization"), "vapid ")
+		var token, key string
+		for _, part := range strings.Split(authorization, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch name {
+			case "t":
+				token = value
+			case "k":
+				key = value
+			}
+		}
+		if key != keys.PublicKeyString() {
+			t.Errorf(`VAPID key = %q, expected %q`, key, keys.PublicKeyString())
+		}
+		claims := jwt.RegisteredClaims{}
+		_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+			return &keys.Private.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience("http://"+r.Host), jwt.WithExpirationRequired())
+		if err != nil {
+			t.Errorf(`VAPID JWT rejected = %v`, err)
+		}
+		if claims.Subject != "mailto:ops@chirpy.example" {
+			t.Errorf(`VAPID subject = %q`, claims.Subject)
+		}
+		if r.Header.Get("Content-Encoding") != "aes128gcm" {
+			t.Errorf(`Content-Encoding = %q`, r.Header.Get("Content-Encoding"))
+		}
+
+		ttl = r.Header.Get("TTL")
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	sub := Subscription{
+		Endpoint: server.URL + "/push/abc",
+		P256dh:   base64.RawURLEncoding.EncodeToString(uaPrivate.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(authSecret),
+	}
+	sender := NewSender(keys)
+	// The test server is on loopback, which the default client refuses.
+	sender.Client = server.Client()
+	if err := sender.Send(context.Background(), sub, []byte(`{"title":"hi"}`), time.Hour); err != nil {
+		t.Fatalf(`Send failed = %v`, err)
+	}
+	if ttl != "3600" {
+		t.Errorf(`TTL = %q, expected 3600`, ttl)
+	}
+	plaintext, err := decrypt(received, uaPrivate, authSecret)
+	if err != nil {
+		t.Fatalf(`decrypt failed = %v`, err)
+	}
+	if string(plaintext) != `{"title":"hi"}` {
+		t.Errorf(`decrypted payload = %q`, plaintext)
+	}
+
+	sub.Endpoint = server.URL + "/gone"
+	if err := sender.Send(context.Background(), sub, []byte("x"), time.Hour); !errors.Is(err, ErrSubscriptionGone) {
+		t.Errorf(`Send to a gone subscription returned %v, expected ErrSubscriptionGone`, err)
+	}
+}
+
+func TestParseVAPIDKeys(t *testing.T) {
+	keys, _ := GenerateVAPIDKeys("mailto:ops@chirpy.example")
+	parsed, err := ParseVAPIDKeys(keys.PrivateKeyString(), keys.Subject)
+	if err != nil {
+		t.Fatalf(`ParseVAPIDKeys failed = %v`, err)
+	}
+	if parsed.PublicKeyString() != keys.PublicKeyString() {
+		t.Errorf(`parsed public key %q, expected %q`, parsed.PublicKeyString(), keys.PublicKeyString())
+	}
+}
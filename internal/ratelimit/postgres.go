@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+/*
+Refill and take from a bucket in one statement, so concurrent requests on
+any instance see a consistent count. The refill is computed from the
+database clock to keep instances with drifting clocks in agreement.
+*/
+const takeQuery = `
+INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at, allowed)
+VALUES ($1, $2::DOUBLE PRECISION - 1, NOW(), TRUE)
+ON CONFLICT (key) DO UPDATE
+SET tokens = CASE
+		WHEN LEAST($2::DOUBLE PRECISION, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::DOUBLE PRECISION) >= 1
+		THEN LEAST($2::DOUBLE PRECISION, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::DOUBLE PRECISION) - 1
+		ELSE LEAST($2::DOUBLE PRECISION, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::DOUBLE PRECISION)
+	END,
+	allowed = LEAST($2::DOUBLE PRECISION, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::DOUBLE PRECISION) >= 1,
+	updated_at = NOW()
+RETURNING tokens, allowed`
+
+// Keeps buckets in Postgres so that every instance shares the same limits.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func (s PostgresStore) Take(ctx context.Context, key string, quota Quota) (Result, error) {
+	var tokens float64
+	var allowed bool
+	err := s.DB.QueryRowContext(ctx, takeQuery, key, quota.Limit, quota.rate()).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(quota, tokens, allowed), nil
+}
+
+// Delete buckets untouched for longer than olderThan, which should be at least the longest window.
+func (s PostgresStore) Prune(ctx context.Context, olderThan time.Duration) error {
+	_, err := s.DB.ExecContext(ctx,
+		"DELETE FROM rate_limit_buckets WHERE updated_at < NOW() - make_interval(secs => $1)",
+		olderThan.Seconds())
+	return err
+}
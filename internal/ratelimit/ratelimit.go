@@ -0,0 +1,63 @@
+// Package ratelimit implements token bucket rate limits over pluggable stores.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+/*
+A Quota allows Limit requests in a burst, refilling at Limit per Window, so
+a client that waits a whole Window gets its full burst back.
+*/
+type Quota struct {
+	Limit  int
+	Window time.Duration
+}
+
+func (q Quota) rate() float64 {
+	return float64(q.Limit) / q.Window.Seconds()
+}
+
+// The RateLimit-Policy header value, e.g. "10;w=60".
+func (q Quota) Policy() string {
+	return fmt.Sprintf("%d;w=%d", q.Limit, int(math.Ceil(q.Window.Seconds())))
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// How long until the bucket is full again.
+	Reset time.Duration
+	// How long until the next request would be allowed; zero when allowed.
+	RetryAfter time.Duration
+}
+
+// Build the result from the tokens left in the bucket after the request.
+func newResult(q Quota, tokens float64, allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     q.Limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     secondsDuration((float64(q.Limit) - tokens) / q.rate()),
+	}
+	if !allowed {
+		result.RetryAfter = secondsDuration((1 - tokens) / q.rate())
+	}
+	return result
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+type Store interface {
+	// Take one token from the bucket for key, creating a full bucket if there is none.
+	Take(ctx context.Context, key string, quota Quota) (Result, error)
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func testStore(now *time.Time) *MemoryStore {
+	store := NewMemoryStore()
+	store.now = func() time.Time { return *now }
+	return store
+}
+
+func TestMemoryStoreBurst(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := testStore(&now)
+	quota := Quota{Limit: 3, Window: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		result, _ := store.Take(context.Background(), "a", quota)
+		if !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf(`request %d got %+v`, i, result)
+		}
+	}
+	result, _ := store.Take(context.Background(), "a", quota)
+	if result.Allowed {
+		t.Fatalf(`request beyond the burst was allowed`)
+	}
+	if result.RetryAfter != 20*time.Second {
+		t.Errorf(`RetryAfter is %v, expected 20s`, result.RetryAfter)
+	}
+	if result.Reset != time.Minute {
+		t.Errorf(`Reset is %v, expected 1m`, result.Reset)
+	}
+
+	if result, _ := store.Take(context.Background(), "b", quota); !result.Allowed {
+		t.Errorf(`a different key shared the first key's bucket`)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := testStore(&now)
+	quota := Quota{Limit: 2, Window: time.Minute}
+
+	store.Take(context.Background(), "a", quota)
+	store.Take(context.Background(), "a", quota)
+
+	now = now.Add(30 * time.Second)
+	result, _ := store.Take(context.Background(), "a", quota)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf(`half a window later got %+v, expected one token`, result)
+	}
+
+	// Waiting longer than a window never fills the bucket past its limit.
+	now = now.Add(time.Hour)
+	result, _ = store.Take(context.Background(), "a", quota)
+	if result.Remaining != 1 {
+		t.Errorf(`after a long wait %d tokens remain, expected 1`, result.Remaining)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := testStore(&now)
+	store.Take(context.Background(), "a", Quota{Limit: 1, Window: time.Minute})
+
+	now = now.Add(2 * time.Minute)
+	store.Take(context.Background(), "b", Quota{Limit: 1, Window: time.Minute})
+	if _, ok := store.buckets["a"]; ok {
+		t.Errorf(`idle bucket was not swept`)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	if policy := (Quota{Limit: 10, Window: time.Minute}).Policy(); policy != "10;w=60" {
+		t.Errorf(`Policy returned %q`, policy)
+	}
+}
@@ -0,0 +1,96 @@
+// Package safehttp builds HTTP clients for requests to URLs supplied by
+// users, such as webhook and push endpoints, which must not be able to reach
+// the server's own network.
+package safehttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+var ErrDisallowedAddress = errors.New("destination address is not allowed")
+
+// Ranges that are not covered by the netip predicates used in Allowed.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+/*
+Report whether an address is on the public internet. Loopback, private,
+link-local (which includes cloud metadata at 169.254.169.254), unspecified
+and multicast addresses are refused, as are IPv4 addresses mapped into IPv6.
+*/
+func Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Runs after DNS resolution, so a hostname that resolves to an internal address is caught too.
+func control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !Allowed(addr) {
+		return fmt.Errorf("%w: %s", ErrDisallowedAddress, addr)
+	}
+	return nil
+}
+
+/*
+A client that only connects to public addresses and does not follow
+redirects; a 3xx is returned to the caller as is. Proxy settings from the
+environment are ignored, since the proxy would make the connection for us.
+*/
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: control}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Reject a URL whose host is a literal address that NewClient would refuse, so the user hears about it up front.
+func CheckHost(host string) error {
+	if host == "localhost" {
+		return ErrDisallowedAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !Allowed(addr) {
+		return ErrDisallowedAddress
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package spam
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+NearDuplicate rejects chirps that are nearly the same as one the author
+posted within Window. Chirps are compared as sets of word pairs (shingles);
+two chirps are duplicates when their Jaccard similarity reaches Threshold.
+Chirps shorter than MinWords are left alone so short replies can repeat.
+*/
+type NearDuplicate struct {
+	MinWords  int
+	Threshold float64
+	Window    time.Duration
+}
+
+func (check NearDuplicate) Name() string { return "near_duplicate" }
+
+func (check NearDuplicate) Evaluate(candidate Candidate) Result {
+	words := normalizedWords(candidate.Body)
+	if len(words) < check.MinWords {
+		return Result{Verdict: Allow}
+	}
+	shingles := shingle(words)
+	for _, recent := range candidate.Recent {
+		if candidate.Now.Sub(recent.CreatedAt) > check.Window {
+			break
+		}
+		if jaccard(shingles, shingle(normalizedWords(recent.Body))) >= check.Threshold {
+			return Result{Verdict: Reject, Reason: "Chirp duplicates one you recently posted"}
+		}
+	}
+	return Result{Verdict: Allow}
+}
+
+func normalizedWords(body string) []string {
+	return strings.FieldsFunc(strings.ToLower(body), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func shingle(words []string) map[string]struct{} {
+	shingles := make(map[string]struct{}, len(words))
+	if len(words) == 1 {
+		shingles[words[0]] = struct{}{}
+	}
+	for i := 0; i+1 < len(words); i++ {
+		shingles[words[i]+" "+words[i+1]] = struct{}{}
+	}
+	return shingles
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for s := range a {
+		if _, ok := b[s]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// Dot-separated labels ending in an alphabetic TLD.
+const domainName = `(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}`
+
+// Links with a scheme or www., and bare domains followed by a path.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s]+|\b` + domainName + `/[^\s]*`)
+
+var domainPattern = regexp.MustCompile(`(?i)\b` + domainName + `\b`)
+
+/*
+TLDs that make a bare name like example.com count as a link. File names such as
+main.go or index.html look just like domains, so any other bare name is only
+checked against the blocked domains.
+*/
+var linkTLDs = map[string]bool{
+	"com": true, "net": true, "org": true, "info": true, "biz": true,
+	"io": true, "co": true, "me": true, "us": true, "uk": true,
+	"ru": true, "cn": true, "tk": true, "xyz": true, "top": true,
+	"site": true, "online": true, "shop": true, "club": true, "app": true,
+	"dev": true, "ly": true, "gg": true, "tv": true, "link": true,
+}
+
+func links(body string) []string {
+	spans := linkPattern.FindAllStringIndex(body, -1)
+	found := make([]string, 0, len(spans))
+	for _, span := range spans {
+		found = append(found, body[span[0]:span[1]])
+	}
+	for _, name := range bareDomains(body, spans) {
+		if linkTLDs[strings.ToLower(name[strings.LastIndex(name, ".")+1:])] {
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+// Bare names in the body that are not part of one of the given links.
+func bareDomains(body string, linkSpans [][]int) []string {
+	var names []string
+	for _, span := range domainPattern.FindAllStringIndex(body, -1) {
+		inLink := false
+		for _, linkSpan := range linkSpans {
+			if span[0] >= linkSpan[0] && span[1] <= linkSpan[1] {
+				inLink = true
+				break
+			}
+		}
+		if !inLink {
+			names = append(names, body[span[0]:span[1]])
+		}
+	}
+	return names
+}
+
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+/*
+Links rejects chirps linking to a blocked domain or any of its subdomains,
+and holds chirps with more than MaxLinks links or whose text is mostly links.
+*/
+type Links struct {
+	MaxLinks       int
+	MaxLinkRatio   float64
+	BlockedDomains []string
+}
+
+func (check Links) Name() string { return "links" }
+
+func (check Links) Evaluate(candidate Candidate) Result {
+	found := links(candidate.Body)
+	// Bare names are checked whatever their TLD, so a blocked domain cannot
+	// slip through by not looking enough like a link.
+	hosts := bareDomains(candidate.Body, linkPattern.FindAllStringIndex(candidate.Body, -1))
+	linkLength := 0
+	for _, link := range found {
+		linkLength += len(link)
+		hosts = append(hosts, link)
+	}
+	for _, host := range hosts {
+		host = linkHost(host)
+		for _, domain := range check.BlockedDomains {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+				return Result{Verdict: Reject, Reason: fmt.Sprintf("Links to %s are not allowed", domain)}
+			}
+		}
+	}
+	if len(found) == 0 {
+		return Result{Verdict: Allow}
+	}
+
+	if len(found) > check.MaxLinks {
+		return Result{Verdict: Hold, Reason: "Chirp contains too many links"}
+	}
+	if float64(linkLength)/float64(len(candidate.Body)) > check.MaxLinkRatio {
+		return Result{Verdict: Hold, Reason: "Chirp is mostly links"}
+	}
+	return Result{Verdict: Allow}
+}
+
+// Burst rejects chirps from accounts younger than NewAccountAge that have already posted MaxChirps within Window.
+type Burst struct {
+	NewAccountAge time.Duration
+	Window        time.Duration
+	MaxChirps     int
+}
+
+func (check Burst) Name() string { return "burst" }
+
+func (check Burst) Evaluate(candidate Candidate) Result {
+	if candidate.accountAge() >= check.NewAccountAge {
+		return Result{Verdict: Allow}
+	}
+	count := 0
+	for _, recent := range candidate.Recent {
+		if candidate.Now.Sub(recent.CreatedAt) > check.Window {
+			break
+		}
+		count++
+	}
+	if count >= check.MaxChirps {
+		return Result{Verdict: Reject, Reason: "Posting too quickly, try again shortly"}
+	}
+	return Result{Verdict: Allow}
+}
+
+// AccountAge holds chirps with links from accounts younger than MinAge.
+type AccountAge struct {
+	MinAge time.Duration
+}
+
+func (check AccountAge) Name() string { return "account_age" }
+
+func (check AccountAge) Evaluate(candidate Candidate) Result {
+	if candidate.accountAge() < check.MinAge && len(links(candidate.Body)) > 0 {
+		return Result{Verdict: Hold, Reason: "New accounts cannot post links yet"}
+	}
+	return Result{Verdict: Allow}
+}
@@ -0,0 +1,88 @@
+// Package spam decides whether a new chirp should be published, held for a
+// moderator or rejected outright, by running it through a chain of checks.
+package spam
+
+import (
+	"time"
+)
+
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	Hold
+	Reject
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Hold:
+		return "hold"
+	case Reject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+// A chirp that is about to be posted, along with what is known about its author.
+type Candidate struct {
+	Body             string
+	AccountCreatedAt time.Time
+	// The author's most recent chirps, newest first.
+	Recent []RecentChirp
+	Now    time.Time
+}
+
+type RecentChirp struct {
+	Body      string
+	CreatedAt time.Time
+}
+
+func (c Candidate) accountAge() time.Duration {
+	return c.Now.Sub(c.AccountCreatedAt)
+}
+
+type Result struct {
+	Verdict Verdict
+	// The check that produced the verdict, empty when allowed.
+	Check  string
+	Reason string
+}
+
+type Check interface {
+	Name() string
+	Evaluate(Candidate) Result
+}
+
+// Checks run in order. The most severe verdict wins and a rejection stops the chain.
+type Chain []Check
+
+func (chain Chain) Evaluate(candidate Candidate) Result {
+	if candidate.Now.IsZero() {
+		candidate.Now = time.Now()
+	}
+	result := Result{Verdict: Allow}
+	for _, check := range chain {
+		checkResult := check.Evaluate(candidate)
+		if checkResult.Verdict <= result.Verdict {
+			continue
+		}
+		checkResult.Check = check.Name()
+		result = checkResult
+		if result.Verdict == Reject {
+			break
+		}
+	}
+	return result
+}
+
+// The chain used when nothing else is configured.
+func DefaultChain(blockedDomains []string) Chain {
+	return Chain{
+		NearDuplicate{MinWords: 4, Threshold: 0.7, Window: 24 * time.Hour},
+		Links{MaxLinks: 2, MaxLinkRatio: 0.6, BlockedDomains: blockedDomains},
+		Burst{NewAccountAge: 24 * time.Hour, Window: time.Minute, MaxChirps: 5},
+		AccountAge{MinAge: 10 * time.Minute},
+	}
+}
@@ -0,0 +1,110 @@
+package spam
+
+import (
+	"testing"
+	"time"
+)
+
+var now = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestNearDuplicate(t *testing.T) {
+	check := NearDuplicate{MinWords: 4, Threshold: 0.7, Window: 24 * time.Hour}
+	recent := []RecentChirp{{
+		Body:      "Win a free phone today by clicking the link in my profile now",
+		CreatedAt: now.Add(-time.Hour),
+	}}
+
+	cases := []struct {
+		name string
+		body string
+		want Verdict
+	}{
+		{"copy", "Win a free phone today by clicking the link in my profile now", Reject},
+		{"one word changed", "Win a free tablet today by clicking the link in my profile now!!", Reject},
+		{"different", "Had a lovely walk in the park with the dog this morning", Allow},
+		{"too short", "gm gm", Allow},
+	}
+	for _, c := range cases {
+		result := check.Evaluate(Candidate{Body: c.body, Recent: recent, Now: now})
+		if result.Verdict != c.want {
+			t.Errorf(`%s: verdict %v, expected %v`, c.name, result.Verdict, c.want)
+		}
+	}
+
+	old := []RecentChirp{{Body: recent[0].Body, CreatedAt: now.Add(-48 * time.Hour)}}
+	if result := check.Evaluate(Candidate{Body: recent[0].Body, Recent: old, Now: now}); result.Verdict != Allow {
+		t.Errorf(`chirp outside the window was treated as a duplicate`)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	check := Links{MaxLinks: 2, MaxLinkRatio: 0.6, BlockedDomains: []string{"spam.example"}}
+
+	cases := []struct {
+		name string
+		body string
+		want Verdict
+	}{
+		{"no links", "Just chirping", Allow},
+		{"one link", "Read my write-up on Go generics at https://blog.example/generics", Allow},
+		{"blocked domain", "Deals at https://spam.example/x", Reject},
+		{"blocked subdomain", "Deals at www.shop.SPAM.example", Reject},
+		{"lookalike domain", "Not blocked, I promise you, https://notspam.example", Allow},
+		{"bare blocked domain", "Deals over at spam.example/x today, hurry up now", Reject},
+		{"bare blocked subdomain", "Deals over at shop.spam.example, hurry up now", Reject},
+		{"decimal number", "Pi is roughly 3.14 and e is roughly 2.72", Allow},
+		{"file names", "Fixed index.html, main.go and file.txt, then tried it under node.js", Allow},
+		{"bare domains", "Try shop-a.com or shop-b.net or shop-c.org today", Hold},
+		{"too many", "a https://a.example b https://b.example c https://c.example", Hold},
+		{"mostly links", "see https://a.example/a-very-long-path", Hold},
+	}
+	for _, c := range cases {
+		result := check.Evaluate(Candidate{Body: c.body, Now: now})
+		if result.Verdict != c.want {
+			t.Errorf(`%s: verdict %v, expected %v`, c.name, result.Verdict, c.want)
+		}
+	}
+}
+
+func TestBurst(t *testing.T) {
+	check := Burst{NewAccountAge: 24 * time.Hour, Window: time.Minute, MaxChirps: 3}
+	recent := []RecentChirp{
+		{Body: "1", CreatedAt: now.Add(-10 * time.Second)},
+		{Body: "2", CreatedAt: now.Add(-20 * time.Second)},
+		{Body: "3", CreatedAt: now.Add(-30 * time.Second)},
+	}
+
+	newAccount := Candidate{Body: "4", AccountCreatedAt: now.Add(-time.Hour), Recent: recent, Now: now}
+	if result := check.Evaluate(newAccount); result.Verdict != Reject {
+		t.Errorf(`burst from a new account was not rejected`)
+	}
+	oldAccount := Candidate{Body: "4", AccountCreatedAt: now.Add(-48 * time.Hour), Recent: recent, Now: now}
+	if result := check.Evaluate(oldAccount); result.Verdict != Allow {
+		t.Errorf(`established account was rate limited`)
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain := DefaultChain([]string{"spam.example"})
+	fresh := now.Add(-time.Minute)
+
+	result := chain.Evaluate(Candidate{Body: "Hello chirpy", AccountCreatedAt: fresh, Now: now})
+	if result.Verdict != Allow || result.Check != "" {
+		t.Errorf(`plain chirp got %+v`, result)
+	}
+
+	result = chain.Evaluate(Candidate{Body: "My new blog is up at https://blog.example", AccountCreatedAt: fresh, Now: now})
+	if result.Verdict != Hold || result.Check != "account_age" {
+		t.Errorf(`link from a new account got %+v`, result)
+	}
+
+	// The blocked domain is rejected even though the new account would only be held for linking.
+	result = chain.Evaluate(Candidate{Body: "https://a.example https://b.example https://spam.example", AccountCreatedAt: fresh, Now: now})
+	if result.Verdict != Reject || result.Check != "links" {
+		t.Errorf(`blocked domain got %+v`, result)
+	}
+
+	if result := (Chain{}).Evaluate(Candidate{Body: "anything"}); result.Verdict != Allow {
+		t.Errorf(`empty chain did not allow`)
+	}
+}
@@ -0,0 +1,110 @@
+package stream
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+Carries events from whichever instance produced them to the Hub of every
+instance, so subscribers see the same events no matter which server
+process their connection landed on.
+*/
+type Bus interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Delivers events straight to a Hub in this process. Suitable for a single instance.
+type LocalBus struct {
+	Hub *Hub
+}
+
+func (b LocalBus) Publish(ctx context.Context, event Event) error {
+	b.Hub.Publish(event)
+	return nil
+}
+
+const (
+	// The Postgres channel events are sent on.
+	NotifyChannel = "chirpy_events"
+	// Postgres rejects NOTIFY payloads of 8000 bytes or more.
+	maxNotifyPayload = 7999
+)
+
+/*
+Fans events out across instances with Postgres LISTEN/NOTIFY. Publish sends
+a NOTIFY over the shared database pool and Listen relays every notification,
+including this instance's own, into the local Hub, so each event reaches
+each Hub exactly once.
+*/
+type PostgresBus struct {
+	DB  *sql.DB
+	Hub *Hub
+}
+
+func (b PostgresBus) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		return fmt.Errorf("event payload is %d bytes, larger than NOTIFY allows", len(payload))
+	}
+	_, err = b.DB.ExecContext(ctx, "SELECT pg_notify($1, $2)", NotifyChannel, string(payload))
+	return err
+}
+
+/*
+Relay notifications into the Hub until ctx is cancelled. LISTEN needs a
+dedicated connection, so this opens its own from dsn and reconnects on
+failure. Events sent while disconnected are lost here; stream clients
+recover them by resuming from their last event ID.
+*/
+func (b PostgresBus) Listen(ctx context.Context, dsn string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			fmt.Println("Error: event bus listener -", err.Error())
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(NotifyChannel); err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification, ok := <-listener.Notify:
+			if !ok {
+				return errors.New("event bus listener closed")
+			}
+			// A nil notification marks a reconnect.
+			if notification == nil {
+				continue
+			}
+			event, err := DecodeNotification(notification.Extra)
+			if err != nil {
+				fmt.Println("Error: malformed event bus notification -", err.Error())
+				continue
+			}
+			b.Hub.Publish(event)
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
+
+func DecodeNotification(payload string) (Event, error) {
+	event := Event{}
+	err := json.Unmarshal([]byte(payload), &event)
+	return event, err
+}
@@ -0,0 +1,118 @@
+package stream
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type Event struct {
+	ID       int64           `json:"id"`
+	Type     string          `json:"type"`
+	ChirpID  uuid.UUID       `json:"chirp_id"`
+	AuthorID uuid.UUID       `json:"author_id"`
+	Hashtags []string        `json:"hashtags"`
+	Data     json.RawMessage `json:"data"`
+	// The parent chirp, when the event is about a reply.
+	ReplyToID uuid.NullUUID `json:"reply_to_id"`
+	// Set for private events, such as notifications, meant for a single user.
+	RecipientID uuid.NullUUID `json:"recipient_id"`
+}
+
+/*
+Fans published events out to every live subscriber in this process.
+Publishing never blocks: a subscriber that falls too far behind is closed,
+and its client is expected to reconnect and resume from its last event ID.
+*/
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	C   <-chan Event
+	c   chan Event
+	hub *Hub
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+func (h *Hub) Subscribe(buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	sub := &Subscription{C: c, c: c, hub: h}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		close(s.c)
+	}
+}
+
+func (h *Hub) Publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		select {
+		case sub.c <- event:
+		default:
+			delete(h.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Selects which events a subscriber wants. Empty fields match everything.
+type Filter struct {
+	AuthorID  uuid.NullUUID
+	Hashtag   string
+	Followees []uuid.UUID
+	Following bool
+	// Authors the subscriber has blocked, been blocked by, or muted.
+	Hidden []uuid.UUID
+}
+
+func (f Filter) Match(event Event) bool {
+	if event.RecipientID.Valid {
+		return false
+	}
+	if f.AuthorID.Valid && event.AuthorID != f.AuthorID.UUID {
+		return false
+	}
+	if f.Hashtag != "" && !slices.Contains(event.Hashtags, strings.ToLower(f.Hashtag)) {
+		return false
+	}
+	if f.Following && !slices.Contains(f.Followees, event.AuthorID) {
+		return false
+	}
+	if slices.Contains(f.Hidden, event.AuthorID) {
+		return false
+	}
+	return true
+}
+
+var hashtagPattern = regexp.MustCompile(`#(\w+)`)
+
+// The distinct lowercased hashtags in a chirp body, without the leading '#'.
+func Hashtags(body string) []string {
+	var tags []string
+	for _, match := range hashtagPattern.FindAllStringSubmatch(body, -1) {
+		tag := strings.ToLower(match[1])
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,103 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHashtags(t *testing.T) {
+	tags := Hashtags("Loving #Go and #golang, #go again")
+	if !slices.Equal(tags, []string{"go", "golang"}) {
+		t.Errorf(`Hashtags returned %v`, tags)
+	}
+}
+
+func TestPublishToSubscribers(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(1)
+	defer sub.Close()
+
+	hub.Publish(Event{ID: 1, Type: "chirp.created"})
+	event := <-sub.C
+	if event.ID != 1 {
+		t.Errorf(`subscriber received event %d, expected 1`, event.ID)
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(1)
+
+	hub.Publish(Event{ID: 1})
+	hub.Publish(Event{ID: 2})
+
+	<-sub.C
+	if _, ok := <-sub.C; ok {
+		t.Errorf(`slow subscriber was not closed`)
+	}
+	sub.Close()
+}
+
+func TestFilter(t *testing.T) {
+	author := uuid.New()
+	event := Event{AuthorID: author, Hashtags: []string{"go"}}
+
+	if !(Filter{Hashtag: "Go"}).Match(event) {
+		t.Errorf(`hashtag filter should match case-insensitively`)
+	}
+	if (Filter{AuthorID: uuid.NullUUID{UUID: uuid.New(), Valid: true}}).Match(event) {
+		t.Errorf(`author filter matched another author`)
+	}
+	if (Filter{Following: true}).Match(event) {
+		t.Errorf(`following filter matched with no followees`)
+	}
+	if !(Filter{Following: true, Followees: []uuid.UUID{author}}).Match(event) {
+		t.Errorf(`following filter did not match a followee`)
+	}
+	if (Filter{Hidden: []uuid.UUID{author}}).Match(event) {
+		t.Errorf(`filter matched a hidden author`)
+	}
+}
+
+func TestLocalBus(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(1)
+	defer sub.Close()
+
+	bus := LocalBus{Hub: hub}
+	if err := bus.Publish(context.Background(), Event{ID: 3}); err != nil {
+		t.Fatalf(`LocalBus.Publish returned error: %v`, err)
+	}
+	if event := <-sub.C; event.ID != 3 {
+		t.Errorf(`subscriber received event %d, expected 3`, event.ID)
+	}
+}
+
+func TestNotificationRoundTrip(t *testing.T) {
+	sent := Event{
+		ID:          7,
+		Type:        "chirp.created",
+		ChirpID:     uuid.New(),
+		AuthorID:    uuid.New(),
+		Hashtags:    []string{"go"},
+		Data:        json.RawMessage(`{"body":"hi #go"}`),
+		RecipientID: uuid.NullUUID{UUID: uuid.New(), Valid: true},
+	}
+	payload, err := json.Marshal(sent)
+	if err != nil {
+		t.Fatalf(`json.Marshal returned error: %v`, err)
+	}
+
+	received, err := DecodeNotification(string(payload))
+	if err != nil {
+		t.Fatalf(`DecodeNotification returned error: %v`, err)
+	}
+	if received.ID != sent.ID || received.ChirpID != sent.ChirpID || received.RecipientID != sent.RecipientID ||
+		!slices.Equal(received.Hashtags, sent.Hashtags) || string(received.Data) != string(sent.Data) {
+		t.Errorf(`DecodeNotification returned %+v, expected %+v`, received, sent)
+	}
+}
@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Dial the test server and complete the client side of the handshake.
+func dial(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf(`Dial failed = %v`, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf(`ReadResponse failed = %v`, err)
+	}
+	if resp.StatusCode != 101 || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf(`handshake failed - %d %q`, resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return conn, br
+}
+
+func writeClientFrame(conn net.Conn, fin bool, opcode int, payload []byte) {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{first, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+func readServerFrame(t *testing.T, br *bufio.Reader) (int, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatalf(`reading frame failed = %v`, err)
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	io.ReadFull(br, payload)
+	return int(header[0] & 0x0F), payload
+}
+
+func echoServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(opcode, data)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEchoFragmented(t *testing.T) {
+	conn, br := dial(t, echoServer(t))
+
+	writeClientFrame(conn, false, OpText, []byte("hello "))
+	writeClientFrame(conn, true, OpContinuation, []byte("world"))
+
+	opcode, payload := readServerFrame(t, br)
+	if opcode != OpText || string(payload) != "hello world" {
+		t.Errorf(`echo returned %d %q`, opcode, payload)
+	}
+}
+
+func TestPingAndClose(t *testing.T) {
+	conn, br := dial(t, echoServer(t))
+
+	writeClientFrame(conn, true, OpPing, []byte("are you there"))
+	opcode, payload := readServerFrame(t, br)
+	if opcode != OpPong || string(payload) != "are you there" {
+		t.Errorf(`ping answered with %d %q`, opcode, payload)
+	}
+
+	closePayload := binary.BigEndian.AppendUint16(nil, CloseNormal)
+	writeClientFrame(conn, true, OpClose, closePayload)
+	opcode, payload = readServerFrame(t, br)
+	if opcode != OpClose || binary.BigEndian.Uint16(payload) != CloseNormal {
+		t.Errorf(`close answered with %d %v`, opcode, payload)
+	}
+}
+
+func TestRejectsPlainRequest(t *testing.T) {
+	resp, err := http.Get(echoServer(t).URL)
+	if err != nil {
+		t.Fatalf(`Get failed = %v`, err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf(`plain request returned %d, expected 400`, resp.StatusCode)
+	}
+}
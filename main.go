@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/notsoexpert/gowebserver/internal/api"
+	"github.com/notsoexpert/gowebserver/internal/auth"
 	"github.com/notsoexpert/gowebserver/internal/database"
+	"github.com/notsoexpert/gowebserver/internal/mail"
+	"github.com/notsoexpert/gowebserver/internal/push"
+	"github.com/notsoexpert/gowebserver/internal/ratelimit"
+	"github.com/notsoexpert/gowebserver/internal/spam"
+	"github.com/notsoexpert/gowebserver/internal/stream"
 )
 
 func main() {
@@ -18,6 +31,52 @@ func main() {
 	apiCfg.Platform = os.Getenv("PLATFORM")
 	apiCfg.Secret = os.Getenv("SECRET")
 	apiCfg.PolkaKey = os.Getenv("POLKA_KEY")
+	if secrets := os.Getenv("POLKA_WEBHOOK_SECRETS"); secrets != "" {
+		apiCfg.PolkaWebhookSecrets = strings.Split(secrets, ",")
+	}
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if n, err := strconv.Atoi(proxies); err != nil || n < 0 {
+			fmt.Println("Error: ignoring invalid TRUSTED_PROXIES", proxies)
+		} else {
+			apiCfg.TrustedProxies = n
+		}
+	}
+	apiCfg.IdempotencyWindow = api.DefaultIdempotencyWindow
+	if window := os.Getenv("IDEMPOTENCY_WINDOW"); window != "" {
+		if parsed, err := time.ParseDuration(window); err != nil || parsed <= 0 {
+			fmt.Println("Error: ignoring invalid IDEMPOTENCY_WINDOW", window)
+		} else {
+			apiCfg.IdempotencyWindow = parsed
+		}
+	}
+	apiCfg.BaseURL = os.Getenv("BASE_URL")
+	if apiCfg.BaseURL == "" {
+		apiCfg.BaseURL = "http://localhost:8080"
+	}
+	apiCfg.Mailer = mail.LogMailer{}
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		apiCfg.Mailer = mail.SMTPMailer{
+			Addr:     smtpAddr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	} else if mailDir := os.Getenv("MAIL_DIR"); mailDir != "" {
+		apiCfg.Mailer = mail.FileMailer{Dir: mailDir, From: os.Getenv("MAIL_FROM")}
+	}
+	if vapidKey := os.Getenv("VAPID_PRIVATE_KEY"); vapidKey != "" {
+		keys, err := push.ParseVAPIDKeys(vapidKey, os.Getenv("VAPID_SUBJECT"))
+		if err != nil {
+			fmt.Println("Error: push notifications disabled -", err.Error())
+		} else {
+			apiCfg.Push = push.NewSender(keys)
+		}
+	}
+	var blockedDomains []string
+	if domains := os.Getenv("SPAM_BLOCKED_DOMAINS"); domains != "" {
+		blockedDomains = strings.Split(domains, ",")
+	}
+	apiCfg.SpamChecks = spam.DefaultChain(blockedDomains)
 	dbURL := os.Getenv("DB_URL")
 	fmt.Println("Connecting to ", dbURL)
 	db, err := sql.Open("postgres", dbURL)
@@ -25,33 +84,175 @@ func main() {
 		fmt.Println("Error: failed to open database")
 		return
 	}
+	apiCfg.DB = db
 	apiCfg.DBQueries = database.New(db)
+	apiCfg.Stream = stream.NewHub()
+	apiCfg.Gateway = api.NewGateway()
+
+	if len(os.Args) > 1 {
+		if err := runCommand(apiCfg.DBQueries, os.Args[1:]); err != nil {
+			fmt.Println("Error:", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		provider, err := auth.DiscoverOIDCProvider(context.Background(), nil, issuer,
+			os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), os.Getenv("OIDC_REDIRECT_URL"))
+		if err != nil {
+			fmt.Println("Error: single sign-on disabled -", err.Error())
+		} else {
+			apiCfg.OIDC = provider
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Several instances behind a load balancer share events through Postgres.
+	if os.Getenv("EVENT_BUS") == "postgres" {
+		bus := stream.PostgresBus{DB: db, Hub: apiCfg.Stream}
+		apiCfg.Events = bus
+		go func() {
+			if err := bus.Listen(ctx, dbURL); err != nil {
+				fmt.Println("Error: event bus listener stopped -", err.Error())
+			}
+		}()
+	} else {
+		apiCfg.Events = stream.LocalBus{Hub: apiCfg.Stream}
+	}
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		store := ratelimit.PostgresStore{DB: db}
+		apiCfg.RateLimits = store
+		go api.RunEvery(ctx, time.Hour, func(ctx context.Context) {
+			if err := store.Prune(ctx, 24*time.Hour); err != nil {
+				fmt.Println("Error: failed to prune rate limits -", err.Error())
+			}
+		})
+	} else {
+		apiCfg.RateLimits = ratelimit.NewMemoryStore()
+	}
+	go api.RunEvery(ctx, time.Hour, apiCfg.ExpireLapsedSubscriptions)
+	go api.RunEvery(ctx, time.Hour, apiCfg.PruneIdempotencyKeys)
+	go api.RunEvery(ctx, 5*time.Second, apiCfg.DeliverWebhooks)
+	go api.RunEvery(ctx, time.Hour, apiCfg.PruneStreamEvents)
+	go api.RunEvery(ctx, time.Hour, apiCfg.PruneMutedWords)
+	go api.RunEvery(ctx, time.Hour, apiCfg.SendEmailDigests)
 
 	mux := http.NewServeMux()
 	handler := http.StripPrefix("/app", http.FileServer(http.Dir(".")))
 	mux.Handle("/app/", apiCfg.MiddlewareMetricsInc(handler))
 	mux.HandleFunc("GET /api/healthz", api.ReadinessHandler)
 	mux.HandleFunc("GET /api/chirps", apiCfg.GetChirpsHandler)
+	mux.HandleFunc("GET /api/stream", apiCfg.StreamHandler)
+	mux.HandleFunc("GET /api/ws", apiCfg.GatewayHandler)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.GetChirpHandler)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.DeleteChirpHandler)
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.PolkaWebhooksHandler)
-	mux.HandleFunc("POST /api/chirps", apiCfg.PostChirpsHandler)
-	mux.HandleFunc("POST /api/users", apiCfg.CreateUserHandler)
+	mux.Handle("POST /api/polka/webhooks", apiCfg.IdempotentWebhook("polka", http.HandlerFunc(apiCfg.PolkaWebhooksHandler)))
+	mux.Handle("POST /api/chirps", apiCfg.RateLimit(api.PostChirpLimit, apiCfg.Idempotent("chirps", http.HandlerFunc(apiCfg.PostChirpsHandler))))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/like", apiCfg.LikeChirpHandler)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/report", apiCfg.ReportChirpHandler)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", apiCfg.UnlikeChirpHandler)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}/content-flags", apiCfg.SetChirpContentFlagsHandler)
+	mux.Handle("POST /api/users", apiCfg.RateLimit(api.CreateUserLimit, apiCfg.Idempotent("users", http.HandlerFunc(apiCfg.CreateUserHandler))))
 	mux.HandleFunc("PUT /api/users", apiCfg.UpdateCredentialsHandler)
-	mux.HandleFunc("POST /api/login", apiCfg.LoginHandler)
+	mux.HandleFunc("GET /api/users/me", apiCfg.GetCurrentUserHandler)
+	mux.HandleFunc("GET /api/users/me/preferences", apiCfg.GetPreferencesHandler)
+	mux.HandleFunc("PUT /api/users/me/preferences", apiCfg.UpdatePreferencesHandler)
+	mux.HandleFunc("GET /api/users/me/muted-words", apiCfg.GetMutedWordsHandler)
+	mux.HandleFunc("POST /api/users/me/muted-words", apiCfg.CreateMutedWordHandler)
+	mux.HandleFunc("DELETE /api/users/me/muted-words/{mutedWordID}", apiCfg.DeleteMutedWordHandler)
+	mux.HandleFunc("POST /api/keys", apiCfg.CreateAPIKeyHandler)
+	mux.HandleFunc("GET /api/keys", apiCfg.GetAPIKeysHandler)
+	mux.HandleFunc("DELETE /api/keys/{keyID}", apiCfg.RevokeAPIKeyHandler)
+	mux.HandleFunc("GET /api/notifications", apiCfg.GetNotificationsHandler)
+	mux.HandleFunc("POST /api/notifications/read", apiCfg.MarkNotificationsReadHandler)
+	mux.HandleFunc("GET /api/notifications/settings", apiCfg.GetNotificationSettingsHandler)
+	mux.HandleFunc("PUT /api/notifications/settings", apiCfg.UpdateNotificationSettingsHandler)
+	mux.HandleFunc("POST /api/notifications/unsubscribe", apiCfg.UnsubscribeHandler)
+	mux.HandleFunc("GET /api/push/vapid-public-key", apiCfg.GetVAPIDPublicKeyHandler)
+	mux.HandleFunc("POST /api/push/subscriptions", apiCfg.CreatePushSubscriptionHandler)
+	mux.HandleFunc("DELETE /api/push/subscriptions/{subscriptionID}", apiCfg.DeletePushSubscriptionHandler)
+	mux.HandleFunc("POST /api/conversations", apiCfg.CreateConversationHandler)
+	mux.HandleFunc("GET /api/conversations", apiCfg.GetConversationsHandler)
+	mux.HandleFunc("GET /api/conversations/{conversationID}", apiCfg.GetConversationHandler)
+	mux.HandleFunc("GET /api/conversations/{conversationID}/messages", apiCfg.GetMessagesHandler)
+	mux.HandleFunc("POST /api/conversations/{conversationID}/messages", apiCfg.PostMessageHandler)
+	mux.HandleFunc("POST /api/conversations/{conversationID}/read", apiCfg.MarkConversationReadHandler)
+	mux.HandleFunc("PUT /api/conversations/{conversationID}/mute", apiCfg.MuteConversationHandler)
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.FollowUserHandler)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.UnfollowUserHandler)
+	mux.HandleFunc("POST /api/users/{userID}/block", apiCfg.BlockUserHandler)
+	mux.HandleFunc("DELETE /api/users/{userID}/block", apiCfg.UnblockUserHandler)
+	mux.HandleFunc("POST /api/users/{userID}/mute", apiCfg.MuteUserHandler)
+	mux.HandleFunc("DELETE /api/users/{userID}/mute", apiCfg.UnmuteUserHandler)
+	mux.Handle("POST /api/webhooks", apiCfg.Idempotent("webhooks", http.HandlerFunc(apiCfg.CreateWebhookEndpointHandler)))
+	mux.HandleFunc("GET /api/webhooks", apiCfg.GetWebhookEndpointsHandler)
+	mux.HandleFunc("DELETE /api/webhooks/{endpointID}", apiCfg.DeleteWebhookEndpointHandler)
+	mux.HandleFunc("GET /api/webhooks/{endpointID}/deliveries", apiCfg.GetWebhookDeliveriesHandler)
+	mux.HandleFunc("POST /api/oauth/clients", apiCfg.RegisterOAuthClientHandler)
+	mux.HandleFunc("GET /api/oauth/clients/{clientID}", apiCfg.GetOAuthClientHandler)
+	mux.HandleFunc("POST /api/oauth/authorize", apiCfg.ApproveAuthorizationHandler)
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.AuthorizeHandler)
+	mux.HandleFunc("POST /oauth/token", apiCfg.TokenHandler)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.IntrospectHandler)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.OAuthRevokeHandler)
+	mux.Handle("POST /api/login", apiCfg.RateLimit(api.LoginLimit, http.HandlerFunc(apiCfg.LoginHandler)))
+	mux.Handle("POST /api/login/magic", apiCfg.RateLimit(api.MagicLinkLimit, http.HandlerFunc(apiCfg.MagicLinkHandler)))
+	mux.HandleFunc("POST /api/login/magic/consume", apiCfg.ConsumeMagicLinkHandler)
+	mux.HandleFunc("GET /api/login/oidc", apiCfg.OIDCLoginHandler)
+	mux.HandleFunc("GET /api/login/oidc/callback", apiCfg.OIDCCallbackHandler)
 	mux.HandleFunc("POST /api/refresh", apiCfg.RefreshHandler)
 	mux.HandleFunc("POST /api/revoke", apiCfg.RevokeHandler)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.CountRequestsHandler)
-	mux.HandleFunc("POST /admin/reset", apiCfg.ResetRequestsHandler)
+	mux.Handle("GET /admin/metrics", apiCfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.CountRequestsHandler)))
+	mux.Handle("POST /admin/reset", apiCfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.ResetRequestsHandler)))
+	mux.Handle("GET /admin/webhooks/polka", apiCfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.ListPolkaEventsHandler)))
+	mux.Handle("GET /admin/webhooks/polka/{eventID}", apiCfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.GetPolkaEventHandler)))
+	mux.Handle("POST /admin/webhooks/polka/{eventID}/replay", apiCfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.ReplayPolkaEventHandler)))
+	mux.Handle("PUT /admin/users/{userID}/role", apiCfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.SetUserRoleHandler)))
+	mux.Handle("GET /admin/moderation/queue", apiCfg.RequireRole(auth.RoleModerator, http.HandlerFunc(apiCfg.ListModerationCasesHandler)))
+	mux.Handle("GET /admin/moderation/queue/{caseID}", apiCfg.RequireRole(auth.RoleModerator, http.HandlerFunc(apiCfg.GetModerationCaseHandler)))
+	mux.Handle("POST /admin/moderation/queue/{caseID}/claim", apiCfg.RequireRole(auth.RoleModerator, http.HandlerFunc(apiCfg.ClaimModerationCaseHandler)))
+	mux.Handle("POST /admin/moderation/queue/{caseID}/resolve", apiCfg.RequireRole(auth.RoleModerator, http.HandlerFunc(apiCfg.ResolveModerationCaseHandler)))
+	mux.Handle("GET /admin/moderation/log", apiCfg.RequireRole(auth.RoleModerator, http.HandlerFunc(apiCfg.GetModerationLogHandler)))
+	mux.Handle("PUT /admin/chirps/{chirpID}/content-flags", apiCfg.RequireRole(auth.RoleModerator, http.HandlerFunc(apiCfg.OverrideChirpContentFlagsHandler)))
+	mux.Handle("GET /admin/users/{userID}/account-state", apiCfg.RequireRole(auth.RoleModerator, http.HandlerFunc(apiCfg.GetAccountStateHandler)))
+	mux.Handle("GET /admin/audit", apiCfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.GetAuditLogHandler)))
+	mux.Handle("GET /admin/audit/verify", apiCfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.VerifyAuditLogHandler)))
+	mux.Handle("PUT /admin/users/{userID}/account-state", apiCfg.RequireRole(auth.RoleAdmin, http.HandlerFunc(apiCfg.SetAccountStateHandler)))
 
 	server := &http.Server{
 		Addr:    ":8080",
-		Handler: mux,
+		Handler: apiCfg.MiddlewareRequestInfo(mux),
+		// Long-lived streams watch the request context, so cancel it on shutdown.
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		fmt.Println("Server shutting down...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		// Shutdown does not track hijacked connections, so drain WebSockets alongside it.
+		drained := make(chan struct{})
+		go func() {
+			apiCfg.Gateway.Drain(shutdownCtx)
+			close(drained)
+		}()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			fmt.Println(err.Error())
+		}
+		<-drained
+	}()
+
 	fmt.Println("Running server...")
-	if ok := server.ListenAndServe(); ok != nil {
+	if ok := server.ListenAndServe(); ok != nil && ok != http.ErrServerClosed {
 		fmt.Println(ok.Error())
+		return
 	}
-	fmt.Println("Server shutting down...")
+	<-shutdownDone
 }
@@ -0,0 +1,37 @@
+-- name: LockAuditLog :exec
+-- Held until the transaction ends, so records are chained one at a time.
+SELECT pg_advisory_xact_lock(hashtext('audit_log'));
+
+-- name: GetLatestAuditHash :one
+SELECT hash FROM audit_log
+ORDER BY id DESC
+LIMIT 1;
+
+-- name: InsertAuditLog :one
+INSERT INTO audit_log (created_at, action, actor_id, target_id, ip, user_agent, request_id, details, prev_hash, hash)
+VALUES (
+	$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+RETURNING *;
+
+-- name: ListAuditLog :many
+SELECT * FROM audit_log
+WHERE id < sqlc.arg('before_id')
+-- An action ending in '.' matches every action with that prefix.
+AND (
+	sqlc.narg('action')::TEXT IS NULL
+	OR action = sqlc.narg('action')
+	OR (right(sqlc.narg('action'), 1) = '.' AND starts_with(action, sqlc.narg('action')))
+)
+AND (sqlc.narg('actor_id')::UUID IS NULL OR actor_id = sqlc.narg('actor_id'))
+AND (sqlc.narg('target_id')::UUID IS NULL OR target_id = sqlc.narg('target_id'))
+AND (sqlc.narg('since')::TIMESTAMP IS NULL OR created_at >= sqlc.narg('since'))
+AND (sqlc.narg('until')::TIMESTAMP IS NULL OR created_at < sqlc.narg('until'))
+ORDER BY id DESC
+LIMIT sqlc.arg('max_results');
+
+-- name: GetAuditLogAfter :many
+SELECT * FROM audit_log
+WHERE id > $1
+ORDER BY id
+LIMIT $2;
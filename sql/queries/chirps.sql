@@ -1,14 +1,86 @@
 -- name: PostChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+-- Chirps held by the spam filter are inserted already hidden.
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, reply_to_id, hidden_at, content_warning, sensitive)
 VALUES (
-	gen_random_uuid(), NOW(), NOW(), $1, $2 
+	gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4, $5, $6
 )
 RETURNING *;
 
+-- name: GetRecentChirpsByUser :many
+SELECT body, created_at FROM chirps
+WHERE user_id = $1 AND created_at > $2
+ORDER BY created_at DESC
+LIMIT $3;
+
 -- name: GetChirps :many
 SELECT * FROM chirps
 ORDER BY created_at;
 
+-- name: GetChirp :one
+SELECT * FROM chirps
+WHERE id = $1;
+
+-- name: ListChirps :many
+-- Chirps as a viewer may see them: chirps by anyone on either side of a block
+-- with the viewer are left out, and so are chirps by users the viewer muted,
+-- unless the viewer asked for that author explicitly. Chirps hidden by a
+-- moderator are only shown to their author, as are chirps by shadow-banned
+-- users. A NULL viewer is an anonymous request.
+SELECT chirps.* FROM chirps
+WHERE (sqlc.narg('author_id')::UUID IS NULL OR chirps.user_id = sqlc.narg('author_id'))
+AND (chirps.hidden_at IS NULL OR chirps.user_id = sqlc.narg('viewer_id'))
+AND (
+	chirps.user_id = sqlc.narg('viewer_id')
+	OR NOT EXISTS (
+		SELECT 1 FROM users
+		WHERE users.id = chirps.user_id AND users.account_state = 'shadow_banned'
+	)
+)
+AND NOT EXISTS (
+	SELECT 1 FROM user_blocks
+	WHERE (user_blocks.blocker_id = chirps.user_id AND user_blocks.blocked_id = sqlc.narg('viewer_id'))
+	OR (user_blocks.blocker_id = sqlc.narg('viewer_id') AND user_blocks.blocked_id = chirps.user_id)
+)
+AND (
+	sqlc.narg('author_id')::UUID IS NOT NULL
+	OR NOT EXISTS (
+		SELECT 1 FROM user_mutes
+		WHERE user_mutes.muter_id = sqlc.narg('viewer_id') AND user_mutes.muted_id = chirps.user_id
+	)
+)
+ORDER BY
+	CASE WHEN sqlc.arg('newest_first')::BOOLEAN THEN chirps.created_at END DESC,
+	chirps.created_at ASC;
+
+-- name: GetVisibleChirp :one
+SELECT chirps.* FROM chirps
+WHERE chirps.id = sqlc.arg('id')
+AND (chirps.hidden_at IS NULL OR chirps.user_id = sqlc.narg('viewer_id'))
+AND (
+	chirps.user_id = sqlc.narg('viewer_id')
+	OR NOT EXISTS (
+		SELECT 1 FROM users
+		WHERE users.id = chirps.user_id AND users.account_state = 'shadow_banned'
+	)
+)
+AND NOT EXISTS (
+	SELECT 1 FROM user_blocks
+	WHERE (user_blocks.blocker_id = chirps.user_id AND user_blocks.blocked_id = sqlc.narg('viewer_id'))
+	OR (user_blocks.blocker_id = sqlc.narg('viewer_id') AND user_blocks.blocked_id = chirps.user_id)
+);
+
+-- name: SetChirpContentFlags :one
+UPDATE chirps
+SET content_warning = $2, sensitive = $3, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetChirpContentFlagOverrides :one
+UPDATE chirps
+SET content_warning_override = $2, sensitive_override = $3, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
 -- name: DeleteChirp :exec
 DELETE FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
@@ -0,0 +1,90 @@
+-- name: CreateConversation :one
+INSERT INTO conversations (id, created_at, updated_at, created_by)
+VALUES (
+	gen_random_uuid(), NOW(), NOW(), $1
+)
+RETURNING *;
+
+-- name: AddConversationParticipants :exec
+INSERT INTO conversation_participants (conversation_id, user_id, joined_at)
+SELECT sqlc.arg('conversation_id'), unnest(sqlc.arg('user_ids')::UUID[]), NOW();
+
+-- name: GetDirectConversation :one
+SELECT conversations.* FROM conversations
+JOIN conversation_participants AS a
+	ON a.conversation_id = conversations.id AND a.user_id = sqlc.arg('user_a')
+JOIN conversation_participants AS b
+	ON b.conversation_id = conversations.id AND b.user_id = sqlc.arg('user_b')
+WHERE (
+	SELECT COUNT(*) FROM conversation_participants
+	WHERE conversation_participants.conversation_id = conversations.id
+) = 2
+LIMIT 1;
+
+-- name: GetConversation :one
+SELECT * FROM conversations
+WHERE id = $1;
+
+-- name: GetConversationParticipant :one
+SELECT * FROM conversation_participants
+WHERE conversation_id = $1 AND user_id = $2;
+
+-- name: GetConversationParticipants :many
+SELECT * FROM conversation_participants
+WHERE conversation_id = $1
+ORDER BY joined_at, user_id;
+
+-- name: GetConversationsForUser :many
+SELECT conversations.*,
+	conversation_participants.muted,
+	ARRAY(
+		SELECT others.user_id FROM conversation_participants AS others
+		WHERE others.conversation_id = conversations.id
+		ORDER BY others.joined_at, others.user_id
+	)::UUID[] AS participant_ids,
+	(
+		SELECT COUNT(*) FROM messages
+		WHERE messages.conversation_id = conversations.id
+		AND messages.id > conversation_participants.last_read_message_id
+		AND messages.sender_id <> conversation_participants.user_id
+	) AS unread_count
+FROM conversations
+JOIN conversation_participants ON conversation_participants.conversation_id = conversations.id
+WHERE conversation_participants.user_id = $1
+ORDER BY conversations.updated_at DESC
+LIMIT $2;
+
+-- name: SetConversationMuted :execrows
+UPDATE conversation_participants
+SET muted = $3
+WHERE conversation_id = $1 AND user_id = $2;
+
+-- name: CreateMessage :one
+INSERT INTO messages (created_at, conversation_id, sender_id, body)
+VALUES (
+	NOW(), $1, $2, $3
+)
+RETURNING *;
+
+-- name: TouchConversation :exec
+UPDATE conversations
+SET updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetMessages :many
+SELECT * FROM messages
+WHERE conversation_id = sqlc.arg('conversation_id')
+AND id < sqlc.arg('before_id')
+ORDER BY id DESC
+LIMIT sqlc.arg('max_results');
+
+-- name: MarkConversationRead :execrows
+UPDATE conversation_participants
+SET last_read_message_id = GREATEST(last_read_message_id, sqlc.arg('message_id'))
+WHERE conversation_participants.conversation_id = sqlc.arg('conversation_id')
+AND conversation_participants.user_id = sqlc.arg('user_id')
+AND EXISTS (
+	SELECT 1 FROM messages
+	WHERE messages.id = sqlc.arg('message_id')
+	AND messages.conversation_id = sqlc.arg('conversation_id')
+);
@@ -0,0 +1,35 @@
+-- name: ClaimIdempotencyKey :one
+-- Returns no rows if the key is already held and has not expired. A claim
+-- whose request never finished is a lease: after a minute it can be taken over,
+-- so a crashed request does not lock the key for the whole window.
+INSERT INTO idempotency_keys (scope, key, fingerprint, created_at, expires_at)
+VALUES (
+	$1, $2, $3, NOW(), $4
+)
+ON CONFLICT (scope, key) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint,
+	created_at = EXCLUDED.created_at,
+	expires_at = EXCLUDED.expires_at,
+	status_code = NULL,
+	content_type = NULL,
+	response_body = NULL
+WHERE idempotency_keys.expires_at <= NOW()
+	OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < NOW() - INTERVAL '1 minute')
+RETURNING *;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE scope = $1 AND key = $2;
+
+-- name: SaveIdempotentResponse :exec
+UPDATE idempotency_keys
+SET status_code = $4, content_type = $5, response_body = $6
+WHERE scope = $1 AND key = $2 AND created_at = $3;
+
+-- name: ReleaseIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE scope = $1 AND key = $2 AND created_at = $3 AND status_code IS NULL;
+
+-- name: DeleteExpiredIdempotencyKeys :exec
+DELETE FROM idempotency_keys
+WHERE expires_at <= NOW();
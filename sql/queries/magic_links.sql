@@ -0,0 +1,15 @@
+-- name: CreateMagicLink :execrows
+-- Inserts nothing if the user was sent a link after sent_after, so repeated
+-- requests cannot flood their inbox.
+INSERT INTO magic_links (token_hash, created_at, user_id, expires_at)
+SELECT $1, NOW(), $2, $3
+WHERE NOT EXISTS (
+	SELECT 1 FROM magic_links
+	WHERE user_id = $2 AND created_at > sqlc.arg('sent_after')
+);
+
+-- name: ConsumeMagicLink :one
+UPDATE magic_links
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL
+RETURNING *;
@@ -0,0 +1,86 @@
+-- name: OpenModerationCase :one
+INSERT INTO moderation_cases (id, created_at, updated_at, chirp_id, subject_id, chirp_body)
+VALUES (
+	gen_random_uuid(), NOW(), NOW(), $1, $2, $3
+)
+ON CONFLICT (chirp_id) WHERE status <> 'resolved' DO UPDATE
+SET updated_at = NOW()
+RETURNING *;
+
+-- name: OpenFlaggedModerationCase :one
+INSERT INTO moderation_cases (id, created_at, updated_at, chirp_id, subject_id, chirp_body, flagged_reason)
+VALUES (
+	gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4
+)
+RETURNING *;
+
+-- name: CreateChirpReport :one
+INSERT INTO chirp_reports (id, created_at, case_id, reporter_id, reason, details)
+VALUES (
+	gen_random_uuid(), NOW(), $1, $2, $3, $4
+)
+ON CONFLICT (case_id, reporter_id) DO NOTHING
+RETURNING *;
+
+-- name: ListModerationCases :many
+SELECT moderation_cases.*,
+	(SELECT COUNT(*) FROM chirp_reports WHERE chirp_reports.case_id = moderation_cases.id) AS report_count
+FROM moderation_cases
+WHERE status = $1
+ORDER BY created_at
+LIMIT $2;
+
+-- name: GetModerationCase :one
+SELECT * FROM moderation_cases
+WHERE id = $1;
+
+-- name: GetChirpReportsForCase :many
+SELECT * FROM chirp_reports
+WHERE case_id = $1
+ORDER BY created_at;
+
+-- name: ClaimModerationCase :one
+UPDATE moderation_cases
+SET status = 'claimed', claimed_by = $2, claimed_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND status = 'open'
+RETURNING *;
+
+-- name: ResolveModerationCase :one
+UPDATE moderation_cases
+SET status = 'resolved', resolution = $3, resolved_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND status = 'claimed' AND claimed_by = $2
+RETURNING *;
+
+-- name: AppendModerationLog :one
+INSERT INTO moderation_log (created_at, moderator_id, action, case_id, chirp_id, subject_id, note)
+VALUES (
+	NOW(), $1, $2, $3, $4, $5, $6
+)
+RETURNING *;
+
+-- name: GetModerationLog :many
+SELECT * FROM moderation_log
+WHERE id < sqlc.arg('before_id')
+AND (sqlc.narg('subject_id')::UUID IS NULL OR subject_id = sqlc.narg('subject_id'))
+ORDER BY id DESC
+LIMIT sqlc.arg('max_results');
+
+-- name: HideChirp :exec
+UPDATE chirps
+SET hidden_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: UnhideChirp :exec
+UPDATE chirps
+SET hidden_at = NULL, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetUserAccountState :exec
+-- suspended_until only means something while the state is 'suspended'.
+UPDATE users
+SET account_state = $2, account_state_reason = $3, suspended_until = $4, updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetUserAccountState :one
+SELECT account_state, account_state_reason, suspended_until FROM users
+WHERE id = $1;
@@ -0,0 +1,116 @@
+-- name: CreateNotification :one
+INSERT INTO notifications (created_at, user_id, actor_id, type, chirp_id)
+SELECT NOW(), sqlc.arg('user_id'), sqlc.arg('actor_id'), sqlc.arg('type'), sqlc.arg('chirp_id')
+WHERE NOT EXISTS (
+	SELECT 1 FROM notification_settings
+	WHERE notification_settings.user_id = sqlc.arg('user_id')
+	AND sqlc.arg('type')::TEXT = ANY(notification_settings.disabled_types)
+)
+RETURNING *;
+
+-- name: GetNotifications :many
+SELECT * FROM notifications
+WHERE user_id = sqlc.arg('user_id')
+AND id < sqlc.arg('before_id')
+AND (NOT sqlc.arg('unread_only')::BOOLEAN OR read_at IS NULL)
+ORDER BY id DESC
+LIMIT sqlc.arg('max_results');
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM notifications
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = sqlc.arg('user_id')
+AND id = ANY(sqlc.arg('ids')::BIGINT[])
+AND read_at IS NULL;
+
+-- name: MarkAllNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: GetNotificationSettings :one
+SELECT * FROM notification_settings
+WHERE user_id = $1;
+
+-- name: SetNotificationSettings :one
+INSERT INTO notification_settings (user_id, updated_at, disabled_types, digest_frequency)
+VALUES (
+	$1, NOW(), $2, $3
+)
+ON CONFLICT (user_id) DO UPDATE
+SET updated_at = NOW(), disabled_types = EXCLUDED.disabled_types, digest_frequency = EXCLUDED.digest_frequency
+RETURNING *;
+
+-- name: DisableEmailDigest :exec
+INSERT INTO notification_settings (user_id, updated_at, digest_frequency)
+VALUES (
+	$1, NOW(), 'off'
+)
+ON CONFLICT (user_id) DO UPDATE
+SET updated_at = NOW(), digest_frequency = 'off';
+
+-- name: GetDigestRecipients :many
+SELECT users.id, users.email, users.sensitive_content,
+	COALESCE(notification_settings.digest_frequency, 'off')::TEXT AS digest_frequency,
+	notification_settings.last_digest_at
+FROM users
+LEFT JOIN notification_settings ON notification_settings.user_id = users.id
+WHERE COALESCE(notification_settings.digest_frequency, 'off') <> 'off'
+AND (
+	notification_settings.last_digest_at IS NULL
+	OR notification_settings.last_digest_at < NOW() - CASE notification_settings.digest_frequency
+		WHEN 'weekly' THEN INTERVAL '7 days'
+		ELSE INTERVAL '1 day'
+	END
+)
+AND EXISTS (
+	SELECT 1 FROM notifications
+	WHERE notifications.user_id = users.id
+	AND notifications.read_at IS NULL
+	AND notifications.created_at > COALESCE(notification_settings.last_digest_at, '-infinity')
+)
+ORDER BY users.id
+LIMIT $1;
+
+-- name: GetDigestNotifications :many
+-- The chirp is left out, like GetVisibleChirp would, when the recipient should
+-- no longer see it: it was hidden, its author is shadow banned, or either side
+-- has blocked the other.
+SELECT notifications.id, notifications.created_at, notifications.type,
+	actors.email AS actor_email, chirps.body AS chirp_body,
+	chirps.content_warning AS chirp_content_warning, chirps.sensitive AS chirp_sensitive,
+	chirps.content_warning_override AS chirp_content_warning_override,
+	chirps.sensitive_override AS chirp_sensitive_override
+FROM notifications
+LEFT JOIN users AS actors ON actors.id = notifications.actor_id
+LEFT JOIN chirps ON chirps.id = notifications.chirp_id
+	AND (chirps.hidden_at IS NULL OR chirps.user_id = notifications.user_id)
+	AND (
+		chirps.user_id = notifications.user_id
+		OR NOT EXISTS (
+			SELECT 1 FROM users
+			WHERE users.id = chirps.user_id AND users.account_state = 'shadow_banned'
+		)
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM user_blocks
+		WHERE (user_blocks.blocker_id = chirps.user_id AND user_blocks.blocked_id = notifications.user_id)
+		OR (user_blocks.blocker_id = notifications.user_id AND user_blocks.blocked_id = chirps.user_id)
+	)
+WHERE notifications.user_id = $1
+AND notifications.read_at IS NULL
+AND notifications.created_at > $2
+ORDER BY notifications.id DESC
+LIMIT $3;
+
+-- name: MarkDigestSent :exec
+INSERT INTO notification_settings (user_id, updated_at, last_digest_at)
+VALUES (
+	$1, NOW(), NOW()
+)
+ON CONFLICT (user_id) DO UPDATE
+SET last_digest_at = NOW();
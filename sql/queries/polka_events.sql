@@ -0,0 +1,44 @@
+-- name: RecordPolkaEvent :execrows
+INSERT INTO polka_events (id, received_at, updated_at, event_type, payload, status)
+VALUES (
+	$1, NOW(), NOW(), $2, $3, 'received'
+)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: ClaimPolkaEvent :one
+-- Returns no rows if the event was processed or another attempt holds it. A
+-- claim is a lease: after five minutes an attempt that never finished can be
+-- taken over, so a crashed request does not leave the event stuck.
+UPDATE polka_events
+SET status = 'processing', attempts = attempts + 1, claimed_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND (
+	status IN ('received', 'failed')
+	OR (status = 'processing' AND claimed_at < NOW() - INTERVAL '5 minutes')
+)
+RETURNING *;
+
+-- name: ClaimPolkaEventForReplay :one
+UPDATE polka_events
+SET status = 'processing', attempts = attempts + 1, claimed_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND status IN ('received', 'failed', 'processing')
+RETURNING *;
+
+-- name: MarkPolkaEventProcessed :exec
+UPDATE polka_events
+SET status = 'processed', error = NULL, processed_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: MarkPolkaEventFailed :exec
+UPDATE polka_events
+SET status = 'failed', error = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetPolkaEvent :one
+SELECT * FROM polka_events
+WHERE id = $1;
+
+-- name: ListPolkaEvents :many
+SELECT * FROM polka_events
+WHERE sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text
+ORDER BY received_at DESC
+LIMIT sqlc.arg('max_results');
@@ -0,0 +1,22 @@
+-- name: UpsertPushSubscription :one
+-- Returns no rows if the endpoint is already registered to another user.
+INSERT INTO push_subscriptions (id, created_at, updated_at, user_id, endpoint, p256dh, auth)
+VALUES (
+	gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4
+)
+ON CONFLICT (endpoint) DO UPDATE
+SET updated_at = NOW(), p256dh = EXCLUDED.p256dh, auth = EXCLUDED.auth
+WHERE push_subscriptions.user_id = EXCLUDED.user_id
+RETURNING *;
+
+-- name: GetPushSubscriptionsForUser :many
+SELECT * FROM push_subscriptions
+WHERE user_id = $1;
+
+-- name: DeletePushSubscription :execrows
+DELETE FROM push_subscriptions
+WHERE id = $1 AND user_id = $2;
+
+-- name: DeletePushSubscriptionByEndpoint :exec
+DELETE FROM push_subscriptions
+WHERE endpoint = $1;
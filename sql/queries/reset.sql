@@ -1,4 +1,5 @@
 -- name: Reset :exec
 DELETE FROM users;
 DELETE FROM chirps;
-DELETE FROM refresh_tokens;
\ No newline at end of file
+DELETE FROM refresh_tokens;
+DELETE FROM api_keys;
\ No newline at end of file
@@ -0,0 +1,16 @@
+-- name: CreateStreamEvent :one
+INSERT INTO stream_events (created_at, event_type, chirp_id, user_id, hashtags, payload, reply_to_id)
+VALUES (
+	NOW(), $1, $2, $3, $4, $5, $6
+)
+RETURNING *;
+
+-- name: GetStreamEventsAfter :many
+SELECT * FROM stream_events
+WHERE id > $1
+ORDER BY id
+LIMIT $2;
+
+-- name: DeleteStreamEventsBefore :exec
+DELETE FROM stream_events
+WHERE created_at < $1;
@@ -0,0 +1,43 @@
+-- name: StartSubscriptionPeriod :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status, period_start, period_end)
+VALUES (
+	gen_random_uuid(), NOW(), NOW(), $1, 'active', $2, $3
+)
+ON CONFLICT (user_id) DO UPDATE
+SET status = 'active', period_start = EXCLUDED.period_start, period_end = EXCLUDED.period_end,
+	canceled_at = NULL, updated_at = NOW()
+RETURNING *;
+
+-- name: GetSubscription :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: CancelSubscription :one
+-- period_end can only move earlier, so a grandfathered period gets a real end.
+UPDATE subscriptions
+SET status = 'canceled', canceled_at = NOW(), period_end = LEAST(period_end, $2), updated_at = NOW()
+WHERE user_id = $1 AND status <> 'expired'
+RETURNING *;
+
+-- name: MarkSubscriptionPastDue :one
+UPDATE subscriptions
+SET status = 'past_due', period_end = LEAST(period_end, $2), updated_at = NOW()
+WHERE user_id = $1 AND status <> 'expired'
+RETURNING *;
+
+-- name: EndSubscription :one
+UPDATE subscriptions
+SET status = 'expired', period_end = LEAST(period_end, NOW()), updated_at = NOW()
+WHERE user_id = $1
+RETURNING *;
+
+-- name: ExpireLapsedSubscriptions :execrows
+UPDATE subscriptions
+SET status = 'expired', updated_at = NOW()
+WHERE status <> 'expired' AND period_end <= NOW();
+
+-- name: IsChirpyRed :one
+SELECT EXISTS (
+	SELECT 1 FROM subscriptions
+	WHERE user_id = $1 AND status <> 'expired' AND period_end > NOW()
+);
@@ -8,6 +8,13 @@ RETURNING *;
 -- name: GetUserByEmail :one
 SELECT * FROM users WHERE email = $1;
 
+-- name: GetUserByEmailFold :one
+-- Emails predating case-insensitive matching may differ only in case; the oldest account wins.
+SELECT * FROM users
+WHERE LOWER(email) = LOWER($1)
+ORDER BY created_at
+LIMIT 1;
+
 -- name: GetUser :one
 SELECT * from users WHERE id = $1;
 
@@ -21,12 +28,29 @@ UPDATE users
 SET hashed_password = $2, updated_at = NOW()
 where id = $1;
 
--- name: ActivateChirpyRed :exec
+-- name: SetUserRole :exec
 UPDATE users
-SET is_chirpy_red = true, updated_at = NOW()
+SET role = $2, updated_at = NOW()
 WHERE id = $1;
 
--- name: DeactivateChirpyRed :exec
+-- name: SetUserRoleByEmail :one
 UPDATE users
-SET is_chirpy_red = false, updated_at = NOW()
-WHERE id = $1;
\ No newline at end of file
+SET role = $2, updated_at = NOW()
+WHERE email = $1
+RETURNING *;
+
+-- name: CreateUserWithoutPassword :one
+INSERT INTO users (id, created_at, updated_at, email)
+VALUES (
+	gen_random_uuid(), NOW(), NOW(), $1
+)
+RETURNING *;
+
+-- name: GetSensitiveContentPreference :one
+SELECT sensitive_content FROM users
+WHERE id = $1;
+
+-- name: SetSensitiveContentPreference :exec
+UPDATE users
+SET sensitive_content = $2, updated_at = NOW()
+WHERE id = $1;
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE oidc_login_states (
+	state TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	code_verifier TEXT NOT NULL,
+	nonce TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE user_identities (
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	PRIMARY KEY (issuer, subject)
+);
+
+-- +goose Down
+DROP TABLE user_identities;
+DROP TABLE oidc_login_states;
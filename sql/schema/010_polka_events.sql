@@ -0,0 +1,8 @@
+-- +goose Up
+CREATE TABLE polka_events (
+	id TEXT PRIMARY KEY,
+	received_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE polka_events;
@@ -0,0 +1,34 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	user_id UUID UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	status TEXT NOT NULL CHECK (status IN ('active', 'past_due', 'canceled', 'expired')),
+	period_start TIMESTAMP NOT NULL,
+	period_end TIMESTAMP NOT NULL,
+	canceled_at TIMESTAMP
+);
+
+-- Existing Red users are grandfathered: Polka never told us when their periods
+-- end, so they keep Red until Polka sends an event for them.
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status, period_start, period_end)
+SELECT gen_random_uuid(), NOW(), NOW(), id, 'active', NOW(), TIMESTAMP '9999-12-31 00:00:00'
+FROM users
+WHERE is_chirpy_red;
+
+ALTER TABLE users
+DROP COLUMN is_chirpy_red;
+
+-- +goose Down
+ALTER TABLE users
+ADD COLUMN is_chirpy_red BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE users
+SET is_chirpy_red = true
+WHERE id IN (
+	SELECT user_id FROM subscriptions
+	WHERE status <> 'expired' AND period_end > NOW()
+);
+
+DROP TABLE subscriptions;
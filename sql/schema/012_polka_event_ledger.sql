@@ -0,0 +1,28 @@
+-- +goose Up
+ALTER TABLE polka_events
+ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+ADD COLUMN event_type TEXT NOT NULL DEFAULT '',
+ADD COLUMN payload JSONB NOT NULL DEFAULT '{}',
+ADD COLUMN status TEXT NOT NULL DEFAULT 'processed'
+	CHECK (status IN ('received', 'processing', 'processed', 'failed')),
+ADD COLUMN error TEXT,
+ADD COLUMN attempts INTEGER NOT NULL DEFAULT 1,
+ADD COLUMN processed_at TIMESTAMP;
+
+ALTER TABLE polka_events
+ALTER COLUMN status SET DEFAULT 'received',
+ALTER COLUMN attempts SET DEFAULT 0;
+
+CREATE INDEX polka_events_status_idx ON polka_events (status, received_at);
+
+-- +goose Down
+DROP INDEX polka_events_status_idx;
+
+ALTER TABLE polka_events
+DROP COLUMN updated_at,
+DROP COLUMN event_type,
+DROP COLUMN payload,
+DROP COLUMN status,
+DROP COLUMN error,
+DROP COLUMN attempts,
+DROP COLUMN processed_at;
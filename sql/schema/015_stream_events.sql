@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE stream_events (
+	id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	event_type TEXT NOT NULL,
+	chirp_id UUID NOT NULL,
+	user_id UUID NOT NULL,
+	hashtags TEXT[] NOT NULL,
+	payload JSONB NOT NULL
+);
+
+-- +goose Down
+DROP TABLE stream_events;
@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE notification_settings
+ADD COLUMN digest_frequency TEXT NOT NULL DEFAULT 'off'
+	CHECK (digest_frequency IN ('off', 'daily', 'weekly')),
+ADD COLUMN last_digest_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE notification_settings
+DROP COLUMN last_digest_at,
+DROP COLUMN digest_frequency;
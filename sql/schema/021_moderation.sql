@@ -0,0 +1,64 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN hidden_at TIMESTAMP;
+
+CREATE TABLE moderation_cases (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	chirp_id UUID REFERENCES chirps(id) ON DELETE SET NULL,
+	subject_id UUID REFERENCES users(id) ON DELETE CASCADE,
+	-- Kept so the case still makes sense after the chirp is deleted.
+	chirp_body TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'claimed', 'resolved')),
+	claimed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+	claimed_at TIMESTAMP,
+	resolved_at TIMESTAMP,
+	resolution TEXT
+);
+
+-- At most one unresolved case per chirp; new reports join it.
+CREATE UNIQUE INDEX moderation_cases_open_chirp_idx ON moderation_cases (chirp_id) WHERE status <> 'resolved';
+CREATE INDEX moderation_cases_status_idx ON moderation_cases (status, created_at);
+
+CREATE TABLE chirp_reports (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	case_id UUID NOT NULL REFERENCES moderation_cases(id) ON DELETE CASCADE,
+	reporter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	reason TEXT NOT NULL,
+	details TEXT NOT NULL DEFAULT '',
+	UNIQUE (case_id, reporter_id)
+);
+
+-- Deliberately free of foreign keys so entries outlive the rows they describe.
+CREATE TABLE moderation_log (
+	id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	moderator_id UUID NOT NULL,
+	action TEXT NOT NULL,
+	case_id UUID,
+	chirp_id UUID,
+	subject_id UUID,
+	note TEXT NOT NULL DEFAULT ''
+);
+
+-- +goose StatementBegin
+CREATE FUNCTION moderation_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'moderation_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER moderation_log_append_only
+BEFORE UPDATE OR DELETE ON moderation_log
+FOR EACH ROW EXECUTE FUNCTION moderation_log_append_only();
+
+-- +goose Down
+DROP TABLE moderation_log;
+DROP FUNCTION moderation_log_append_only;
+DROP TABLE chirp_reports;
+DROP TABLE moderation_cases;
+ALTER TABLE chirps
+DROP COLUMN hidden_at;
@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN account_state TEXT NOT NULL DEFAULT 'active'
+	CHECK (account_state IN ('active', 'suspended', 'shadow_banned')),
+ADD COLUMN account_state_reason TEXT NOT NULL DEFAULT '',
+-- Only meaningful while account_state is 'suspended'.
+ADD COLUMN suspended_until TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN suspended_until,
+DROP COLUMN account_state_reason,
+DROP COLUMN account_state;
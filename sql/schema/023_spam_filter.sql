@@ -0,0 +1,11 @@
+-- +goose Up
+-- Set when the spam filter held the chirp rather than a user reporting it.
+ALTER TABLE moderation_cases
+ADD COLUMN flagged_reason TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX chirps_user_created_idx ON chirps (user_id, created_at DESC);
+
+-- +goose Down
+DROP INDEX chirps_user_created_idx;
+ALTER TABLE moderation_cases
+DROP COLUMN flagged_reason;
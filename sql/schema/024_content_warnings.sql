@@ -0,0 +1,20 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN content_warning TEXT NOT NULL DEFAULT '',
+ADD COLUMN sensitive BOOLEAN NOT NULL DEFAULT FALSE,
+-- Set by moderators; NULL leaves the author's choice in effect.
+ADD COLUMN content_warning_override TEXT,
+ADD COLUMN sensitive_override BOOLEAN;
+
+ALTER TABLE users
+ADD COLUMN sensitive_content TEXT NOT NULL DEFAULT 'hide'
+	CHECK (sensitive_content IN ('expand', 'hide'));
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN sensitive_content;
+ALTER TABLE chirps
+DROP COLUMN sensitive_override,
+DROP COLUMN content_warning_override,
+DROP COLUMN sensitive,
+DROP COLUMN content_warning;
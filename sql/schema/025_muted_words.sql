@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE muted_words (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	-- Lowercased, with words separated by single spaces.
+	phrase TEXT NOT NULL,
+	expires_at TIMESTAMP,
+	UNIQUE (user_id, phrase)
+);
+
+-- +goose Down
+DROP TABLE muted_words;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Token buckets for the Postgres rate limit store; see internal/ratelimit.
+CREATE TABLE rate_limit_buckets (
+	key TEXT PRIMARY KEY,
+	tokens DOUBLE PRECISION NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	-- Whether the last request taken from the bucket was allowed.
+	allowed BOOLEAN NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_updated_idx ON rate_limit_buckets (updated_at);
+
+-- +goose Down
+DROP TABLE rate_limit_buckets;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Responses to POST requests that carried an Idempotency-Key, kept so a
+-- retry replays the original response instead of repeating the request.
+CREATE TABLE idempotency_keys (
+	scope TEXT NOT NULL,
+	key TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	-- NULL until the first request finishes.
+	status_code INTEGER,
+	content_type TEXT,
+	response_body BYTEA,
+	PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX idempotency_keys_expires_idx ON idempotency_keys (expires_at);
+
+-- +goose Down
+DROP TABLE idempotency_keys;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Single sign-on matches accounts by email regardless of case.
+CREATE INDEX users_email_lower_idx ON users (LOWER(email));
+
+-- +goose Down
+DROP INDEX users_email_lower_idx;
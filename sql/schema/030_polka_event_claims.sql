@@ -0,0 +1,9 @@
+-- +goose Up
+-- When the current attempt at an event started, so an attempt that died
+-- part way through can be picked up again instead of blocking retries.
+ALTER TABLE polka_events
+ADD COLUMN claimed_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE polka_events
+DROP COLUMN claimed_at;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- The parent of a reply, so thread subscribers hear about replies too.
+ALTER TABLE stream_events
+ADD COLUMN reply_to_id UUID;
+
+-- +goose Down
+ALTER TABLE stream_events
+DROP COLUMN reply_to_id;
@@ -0,0 +1,16 @@
+// Service worker for Web Push. Register it from a page under /app/ and pass
+// the key from /api/push/vapid-public-key to pushManager.subscribe.
+self.addEventListener("push", (event) => {
+  const data = event.data ? event.data.json() : {};
+  event.waitUntil(
+    self.registration.showNotification(data.title || "Chirpy", {
+      body: data.body,
+      data: { url: data.url || "/app/" },
+    }),
+  );
+});
+
+self.addEventListener("notificationclick", (event) => {
+  event.notification.close();
+  event.waitUntil(clients.openWindow(event.notification.data.url));
+});